@@ -8,10 +8,24 @@
 #include <unistd.h>
 
 int pid;
+int hostroot = -1;
+int hostcwd = -1;
+
+/*
+ * Subcommands which don't attach to a process, and so don't need us to join its
+ * namespaces.
+ */
+static const char *subcommands[] = {"info", "run", "handle", NULL};
+
+/*
+ * Subcommands which take either a pid, or something else, such as a core, which
+ * they handle without joining any process's namespaces.
+ */
+static const char *core_subcommands[] = {"goroutines", "group", NULL};
 
 static void
 usage() {
-	fprintf(stderr, "usage: %s pid | gzip >core.gz\n", program_invocation_short_name);
+	fprintf(stderr, "usage: %s [options] target | gzip >core.gz\n       %s [options] -o core target\n       %s pstack [-v] target\n       %s goroutines [-system] target\n       %s goroutines [-system] [-exe path] core\n       %s info [-json] core\n       %s run [options] -- command [args...]\n       %s watch [options] target\n       %s handle [options] pid [tid] <core\n       %s group [options] [-cgroup | -pidns] pid|path\ntarget is a pid, cgroup:path, container:id, cri:id|name, name:regex or cmdline:regex\n", program_invocation_short_name, program_invocation_short_name, program_invocation_short_name, program_invocation_short_name, program_invocation_short_name, program_invocation_short_name, program_invocation_short_name, program_invocation_short_name, program_invocation_short_name, program_invocation_short_name);
 }
 
 static int
@@ -123,24 +137,103 @@ nspid(int pid) {
 	return rv;
 }
 
+/*
+ * `gcore run` starts its command via a copy of ourselves, which waits to be
+ * seized and then execs the command, so that it is traced from the start.
+ * gcore writes a byte to fd once it has seized us.
+ */
+static void
+run_stub(const char *fd, char **argv) {
+	char c;
+	int i = atoi(fd);
+
+	unsetenv("GCORE_RUN_FD");
+
+	if (read(i, &c, 1) != 1) {
+		fprintf(stderr, "gcore run: not seized\n");
+		exit(127);
+	}
+	close(i);
+
+	execvp(argv[0], argv);
+	perror("execvp");
+	exit(127);
+}
+
 __attribute__((constructor)) void
 init(int argc, const char **argv) {
 	char *endptr;
 	int _pid;
 	int need_setns_pid;
 	int need_setns_mnt;
+	const char **sub;
+	const char *fd;
+
+	fd = getenv("GCORE_RUN_FD");
+	if (fd) {
+		run_stub(fd, (char **)argv);
+	}
+
+	if (argc >= 2) {
+		for (sub = subcommands; *sub; sub++) {
+			if (!strcmp(argv[1], *sub)) {
+				return;
+			}
+		}
+
+		for (sub = core_subcommands; *sub; sub++) {
+			if (!strcmp(argv[1], *sub)) {
+				strtol(argv[argc - 1], &endptr, 10);
+				if (*endptr || !*argv[argc - 1]) {
+					return;
+				}
+			}
+		}
+	}
+
+	/*
+	 * Leave -h, and a missing pid after flags, for the Go flag package to
+	 * report with the subcommand's own usage.
+	 */
+	if (argc >= 2 && argv[argc - 1][0] == '-') {
+		return;
+	}
 
-	if (argc != 2 || !*argv[1]) {
+	if (argc < 2 || !*argv[argc - 1]) {
 		usage();
 		exit(1);
 	}
 
-	_pid = strtol(argv[1], &endptr, 10);
+	_pid = strtol(argv[argc - 1], &endptr, 10);
 	if (*endptr || _pid < 1) {
+		/*
+		 * Other targets, e.g. name:regex, are resolved to pids in Go, which
+		 * then runs us again with each.
+		 */
+		if (strchr(argv[argc - 1], ':')) {
+			return;
+		}
+
 		usage();
 		exit(1);
 	}
 
+	/*
+	 * Keep hold of our root and working directories so that output paths can
+	 * still be resolved relative to them after we setns().
+	 */
+	hostroot = open("/", O_PATH | O_DIRECTORY | O_CLOEXEC);
+	if (hostroot < 0) {
+		perror("open");
+		exit(1);
+	}
+
+	hostcwd = open(".", O_PATH | O_DIRECTORY | O_CLOEXEC);
+	if (hostcwd < 0) {
+		perror("open");
+		exit(1);
+	}
+
 	need_setns_pid = nscmp(_pid, "pid");
 	if (need_setns_pid == -1) {
 		exit(1);
@@ -4,15 +4,243 @@ package main
 import "C"
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
+	"golang.org/x/sys/unix"
+
+	"github.com/jim-minter/gcore/pkg/compress"
 	"github.com/jim-minter/gcore/pkg/gcore"
+	"github.com/jim-minter/gcore/pkg/proc"
 )
 
+type filterFlag struct {
+	filter *proc.CoredumpFilter
+}
+
+func (f *filterFlag) String() string {
+	if f.filter == nil {
+		return ""
+	}
+	return f.filter.String()
+}
+
+func (f *filterFlag) Set(s string) error {
+	f.filter = new(proc.CoredumpFilter)
+	return f.filter.Set(s)
+}
+
+var subcommands = map[string]func([]string) error{
+	"info":       infoMain,
+	"goroutines": goroutinesMain,
+	"pstack":     pstackMain,
+	"run":        runMain,
+	"watch":      watchMain,
+	"handle":     handleMain,
+	"group":      groupMain,
+}
+
+// dumpFlags are the flags controlling how cores are captured and written,
+// shared by the subcommands which write cores.
+type dumpFlags struct {
+	filter      filterFlag
+	resident    *bool
+	skipSwapped *bool
+	readers     *int
+	*compressFlags
+}
+
+func addDumpFlags(fs *flag.FlagSet) *dumpFlags {
+	f := &dumpFlags{compressFlags: addCompressFlags(fs)}
+	fs.Var(&f.filter, "filter", "coredump_filter bitmask in hex (default: the target's /proc/<pid>/coredump_filter)")
+	f.resident = fs.Bool("resident", false, "only capture pages which are present or swapped")
+	f.skipSwapped = fs.Bool("skip-swapped", false, "only capture pages which are present (implies -resident)")
+	f.readers = fs.Int("readers", runtime.GOMAXPROCS(0), "number of goroutines reading memory ahead of the writer")
+	return f
+}
+
+// compressFlags are the flags controlling how cores are written.
+type compressFlags struct {
+	format  *string
+	level   *int
+	workers *int
+}
+
+func addCompressFlags(fs *flag.FlagSet) *compressFlags {
+	f := &compressFlags{}
+	f.format = fs.String("compress", "", "compress the core with `format` ("+strings.Join(compress.Formats, ", ")+")")
+	f.level = fs.Int("level", compress.DefaultLevel, "compression level (-1 selects the format's default)")
+	f.workers = fs.Int("workers", runtime.GOMAXPROCS(0), "number of concurrent compression workers")
+	return f
+}
+
+// mainFlags are the flags of gcore run without a subcommand.
+type mainFlags struct {
+	*dumpFlags
+	output  *string
+	catch   *bool
+	fork    *bool
+	precopy *bool
+	freeze  *bool
+}
+
+func addMainFlags(fs *flag.FlagSet) *mainFlags {
+	f := &mainFlags{dumpFlags: addDumpFlags(fs)}
+	f.output = fs.String("o", "", "write the core to `path` rather than stdout")
+	f.catch = fs.Bool("catch", false, "let the target run, and dump it when it is about to be killed by a signal which dumps core")
+	f.fork = fs.Bool("fork", false, "make the target fork, resume it, and dump the copy-on-write child's memory, to keep the pause short")
+	f.precopy = fs.Bool("precopy", false, "copy memory while the target runs, and stop it only to copy again the pages written to since, to keep the pause short")
+	f.freeze = fs.Bool("freeze", false, "stop the target by freezing its cgroup v2 (and so every process in it), rather than with ptrace")
+	addAllFlag(fs)
+	return f
+}
+
+func (f *dumpFlags) options() gcore.Options {
+	return gcore.Options{
+		CoredumpFilter: f.filter.filter,
+		Resident:       *f.resident,
+		SkipSwapped:    *f.skipSwapped,
+		Readers:        *f.readers,
+	}
+}
+
+// writeCore calls dump with output, or stdout if output is empty, compressing
+// what it writes if a format was chosen.
+func (f *compressFlags) writeCore(output string, dump func(io.Writer) error) (err error) {
+	if outputSuffix != "" {
+		if output == "" {
+			return errors.New("-o is needed to write the cores of several processes")
+		}
+		output += outputSuffix
+	}
+
+	out := os.Stdout
+	if output != "" {
+		out, err = createHost(output)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+
+	if *f.format == "" {
+		return dump(out)
+	}
+
+	cw, err := compress.NewWriter(out, *f.format, *f.level, *f.workers)
+	if err != nil {
+		return err
+	}
+
+	err = dump(cw)
+	if cerr := cw.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
 func main() {
-	if err := gcore.Run(int(C.pid)); err != nil {
+	if sub, args, ok := targetArgs(); ok {
+		os.Exit(runTargets(sub, args))
+	}
+
+	if len(os.Args) > 1 {
+		if sub, ok := subcommands[os.Args[1]]; ok {
+			if err := sub(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	f := addMainFlags(flag.CommandLine)
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if n := countTrue(*f.catch, *f.fork, *f.precopy, *f.freeze); n > 1 {
+		fmt.Fprintln(os.Stderr, "-catch, -fork, -precopy and -freeze are mutually exclusive")
+		os.Exit(2)
+	}
+
+	opts := f.options()
+	opts.Fork = *f.fork
+	opts.Precopy = *f.precopy
+
+	if *f.freeze {
+		dir, err := proc.CgroupDir(int(C.pid), hostPath("/"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts.Freeze = dir
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := f.writeCore(*f.output, func(w io.Writer) error {
+		return dump(ctx, w, *f.catch, opts)
+	}); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
+
+func countTrue(bs ...bool) (n int) {
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+
+	return n
+}
+
+func dump(ctx context.Context, w io.Writer, catch bool, opts gcore.Options) error {
+	dump := gcore.Dump
+	if catch {
+		dump = gcore.Catch
+	}
+
+	result, err := dump(ctx, int(C.pid), w, opts)
+	if err != nil {
+		return err
+	}
+
+	if catch {
+		fmt.Fprintf(os.Stderr, "thread %d received %s\n", result.Tid, unix.SignalName(result.Signal))
+	}
+
+	switch {
+	case opts.Fork:
+		fmt.Fprintf(os.Stderr, "resumed the target after %v\n", result.Pause.Round(time.Microsecond))
+	case opts.Precopy:
+		fmt.Fprintf(os.Stderr, "resumed the target after %v, having copied %d bytes again\n", result.Pause.Round(time.Microsecond), result.Recopied)
+	}
+
+	for _, f := range result.Faults {
+		fmt.Fprintf(os.Stderr, "warning: couldn't read %#x-%#x: %v\n", f.Start, f.End, f.Err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+// extern int pid;
+import "C"
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jim-minter/gcore/pkg/core"
+	"github.com/jim-minter/gcore/pkg/goroutine"
+)
+
+// goroutinesFlags are the flags of gcore goroutines.
+type goroutinesFlags struct {
+	system *bool
+	exe    *string
+}
+
+func addGoroutinesFlags(fs *flag.FlagSet) *goroutinesFlags {
+	f := &goroutinesFlags{}
+	f.system = fs.Bool("system", false, "include the runtime's own goroutines, as GOTRACEBACK=system does")
+	f.exe = fs.String("exe", "", "read a core's executable from `path` (default: the path recorded in the core)")
+	addAllFlag(fs)
+	return f
+}
+
+func goroutinesMain(args []string) error {
+	fs := flag.NewFlagSet("goroutines", flag.ExitOnError)
+	gf := addGoroutinesFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s goroutines [-system] pid\n       %s goroutines [-system] [-exe path] core\n", os.Args[0], os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var result *goroutine.Result
+	var err error
+
+	if C.pid != 0 {
+		result, err = goroutine.Dump(int(C.pid))
+	} else {
+		var f *core.File
+		f, err = core.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		result, err = goroutine.FromCore(f, *gf.exe)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeGoroutines(os.Stdout, result, *gf.system)
+}
+
+// writeGoroutines prints goroutines in the format of a Go traceback.
+func writeGoroutines(w io.Writer, result *goroutine.Result, system bool) error {
+	first := true
+
+	for _, g := range result.Goroutines {
+		if g.System && !system {
+			continue
+		}
+
+		if !first {
+			fmt.Fprintln(w)
+		}
+		first = false
+
+		fmt.Fprintf(w, "goroutine %d [%s]:\n", g.ID, g.Status)
+		for _, f := range g.Frames {
+			if system || showFrame(f.Function) {
+				writeFrame(w, f.Function+"(...)", &f)
+			}
+		}
+		if g.Err != nil {
+			fmt.Fprintf(w, "...unwinding stopped: %v\n", g.Err)
+		}
+
+		// As in Go tracebacks, the main goroutine's creator isn't shown.
+		if f := g.CreatedBy; f != nil && f.Function != "" && g.ID != 1 && (system || showFrame(f.Function)) {
+			name := "created by " + f.Function
+			if g.ParentID != 0 {
+				name += fmt.Sprintf(" in goroutine %d", g.ParentID)
+			}
+			writeFrame(w, name, f)
+		}
+	}
+
+	return nil
+}
+
+// showFrame reports whether GOTRACEBACK=all would print a frame of fn: the
+// runtime's unexported functions are hidden.
+func showFrame(fn string) bool {
+	if !strings.HasPrefix(fn, "runtime.") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(fn[len("runtime."):])
+	return unicode.IsUpper(r)
+}
+
+func writeFrame(w io.Writer, name string, f *goroutine.Frame) {
+	if f.Function == "" {
+		fmt.Fprintf(w, "?()\n\t?:0 pc=%#x\n", f.PC)
+		return
+	}
+
+	fmt.Fprintf(w, "%s\n\t%s:%d", name, f.File, f.Line)
+	if f.Offset > 0 {
+		fmt.Fprintf(w, " +%#x", f.Offset)
+	}
+	fmt.Fprintln(w)
+}
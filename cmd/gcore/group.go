@@ -0,0 +1,138 @@
+package main
+
+// extern int pid;
+import "C"
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jim-minter/gcore/pkg/gcore"
+	"github.com/jim-minter/gcore/pkg/proc"
+	"github.com/jim-minter/gcore/pkg/ptrace"
+)
+
+func groupMain(args []string) error {
+	fs := flag.NewFlagSet("group", flag.ExitOnError)
+	df := addDumpFlags(fs)
+	dir := fs.String("o", ".", "write the cores to `dir`/core.<pid>")
+	cgroup := fs.Bool("cgroup", false, "dump the processes in the cgroup v2 directory given, and its descendants")
+	pidns := fs.Bool("pidns", false, "dump the processes in the pid namespace of the pid, or the nsfs file (e.g. /proc/<pid>/ns/pid), given")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s group [options] [-cgroup | -pidns] pid|path\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "By default, dumps the process pid and its descendants.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || (*cgroup && *pidns) {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	pid := int(C.pid)
+
+	var list func() ([]int, error)
+	switch {
+	case *cgroup:
+		list = func() ([]int, error) { return proc.CgroupProcs(fs.Arg(0)) }
+
+	case *pidns:
+		path := fs.Arg(0)
+		if pid != 0 {
+			path = fmt.Sprintf("/proc/%d/ns/pid", pid)
+		}
+		list = func() ([]int, error) { return proc.NamespaceProcs(path) }
+
+	case pid == 0:
+		return fmt.Errorf("invalid pid %q", fs.Arg(0))
+
+	default:
+		list = func() ([]int, error) { return proc.Descendants(pid) }
+	}
+
+	// Fail before stopping anything if the cores can't be written.
+	if err := mkdirHost(*dir); err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	start := time.Now()
+
+	sessions, err := ptrace.SeizeAll(list)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, s := range sessions {
+			if err := s.Detach(); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: detaching %d: %v\n", s.Pid(), err)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "resumed %d processes after %v\n", len(sessions), time.Since(start).Round(time.Millisecond))
+	}()
+
+	if len(sessions) == 0 {
+		return errors.New("no processes found")
+	}
+
+	fmt.Fprintf(os.Stderr, "stopped %d processes in %v\n", len(sessions), time.Since(start).Round(time.Millisecond))
+
+	// Every process is stopped, so they can be dumped in any order.
+	errs := make([]error, len(sessions))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+
+	for i, s := range sessions {
+		wg.Add(1)
+		go func(i int, s *ptrace.Session) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			name := filepath.Join(*dir, fmt.Sprintf("core.%d", s.Pid()))
+			errs[i] = df.writeCore(name, func(w io.Writer) error {
+				result, err := gcore.DumpSession(ctx, s, w, df.options())
+				if err != nil {
+					return err
+				}
+
+				for _, f := range result.Faults {
+					fmt.Fprintf(os.Stderr, "warning: %d: couldn't read %#x-%#x: %v\n", s.Pid(), f.Start, f.End, f.Err)
+				}
+
+				return nil
+			})
+		}(i, s)
+	}
+
+	wg.Wait()
+
+	var failed int
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%d: %v\n", sessions[i].Pid(), err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d processes not dumped", failed, len(sessions))
+	}
+
+	return nil
+}
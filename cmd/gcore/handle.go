@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/jim-minter/gcore/pkg/gcore"
+	pkgnotes "github.com/jim-minter/gcore/pkg/notes"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+// handleMain is a core_pattern handler, e.g.
+//
+//	|/usr/local/bin/gcore handle %P %I
+//
+// which the kernel runs in the initial namespaces, as root, with the core on
+// stdin.
+func handleMain(args []string) error {
+	fs := flag.NewFlagSet("handle", flag.ExitOnError)
+	cf := addCompressFlags(fs)
+	template := fs.String("o", "/var/lib/gcore/{container}/core.{comm}.{pid}.{time}", "write the core to `template`, expanding {pid}, {tid}, {nspid}, {comm}, {container} and {time}")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s handle [options] pid [tid] <core\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	pid, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var tid int
+	if fs.NArg() == 2 {
+		tid, err = strconv.Atoi(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Cores are as sensitive as the memory they hold.
+	unix.Umask(0077)
+
+	// The core is still kept if the process can't be examined.
+	m, merr := pkgnotes.ReadMetadata(pid, tid)
+	if merr != nil {
+		fmt.Fprintf(os.Stderr, "warning: not adding metadata: %v\n", merr)
+		m = &pkgnotes.Metadata{Pid: pid, Tid: tid}
+	}
+
+	name := expand(*template, m, time.Now())
+
+	err = os.MkdirAll(filepath.Dir(name), 0777)
+	if err != nil {
+		return err
+	}
+
+	err = cf.writeCore(name, func(w io.Writer) error {
+		if merr != nil {
+			_, err := io.Copy(w, os.Stdin)
+			return err
+		}
+		return gcore.Enrich(os.Stdin, w, m)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Let the kernel finish writing, in case the core had trailing data.
+	_, err = io.Copy(io.Discard, os.Stdin)
+	return err
+}
+
+// expand expands the placeholders in template for the process described by m.
+func expand(template string, m *pkgnotes.Metadata, now time.Time) string {
+	nspid := m.Pid
+	if len(m.NSpid) > 0 {
+		nspid = m.NSpid[len(m.NSpid)-1]
+	}
+
+	comm := "unknown"
+	if status, err := proc.ReadStatus(m.Pid); err == nil {
+		comm = strings.NewReplacer("/", "_", "\x00", "_").Replace(status.Name)
+	}
+
+	container := m.ContainerID
+	if container == "" {
+		container = "host"
+	}
+
+	return strings.NewReplacer(
+		"{pid}", strconv.Itoa(m.Pid),
+		"{tid}", strconv.Itoa(m.Tid),
+		"{nspid}", strconv.Itoa(nspid),
+		"{comm}", comm,
+		"{container}", container,
+		"{time}", strconv.FormatInt(now.Unix(), 10),
+	).Replace(template)
+}
@@ -0,0 +1,66 @@
+package main
+
+// extern int hostroot;
+// extern int hostcwd;
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// createHost creates path as it would have been resolved before the C
+// constructor entered the target's mount namespace.  Subcommands which don't
+// enter it resolve path as usual.
+func createHost(path string) (*os.File, error) {
+	if C.hostroot < 0 {
+		return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	}
+
+	dirfd, rel := int(C.hostcwd), path
+	if filepath.IsAbs(path) {
+		dirfd, rel = int(C.hostroot), strings.TrimLeft(path, "/")
+	}
+
+	fd, err := unix.Openat(dirfd, rel, unix.O_RDWR|unix.O_CREAT|unix.O_TRUNC|unix.O_CLOEXEC, 0666)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// mkdirHost creates directory path, and any parents, unless it already exists,
+// resolving path as createHost does.
+func mkdirHost(path string) error {
+	if C.hostroot < 0 {
+		return os.MkdirAll(path, 0777)
+	}
+
+	dirfd, rel := C.hostcwd, path
+	if filepath.IsAbs(path) {
+		dirfd, rel = C.hostroot, strings.TrimLeft(path, "/")
+	}
+
+	err := os.MkdirAll(fmt.Sprintf("/proc/self/fd/%d/%s", dirfd, rel), 0777)
+	if perr, ok := err.(*os.PathError); ok {
+		perr.Path = path
+	}
+
+	return err
+}
+
+// hostPath returns a path through which absolute path can be opened as it
+// would have been resolved before the C constructor entered the target's mount
+// namespace.
+func hostPath(path string) string {
+	if C.hostroot < 0 {
+		return path
+	}
+
+	return fmt.Sprintf("/proc/self/fd/%d/%s", C.hostroot, strings.TrimLeft(path, "/"))
+}
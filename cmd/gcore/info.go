@@ -0,0 +1,417 @@
+package main
+
+import (
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/jim-minter/gcore/pkg/core"
+	pkgnotes "github.com/jim-minter/gcore/pkg/notes"
+)
+
+// hex marshals to JSON as a string, as addresses don't fit in a float64.
+type hex uint64
+
+func (h hex) String() string {
+	return fmt.Sprintf("%#x", uint64(h))
+}
+
+func (h hex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.String())
+}
+
+type register struct {
+	Name  string
+	Value hex
+}
+
+// registers marshals to JSON as an object, keeping the registers' order.
+type registers []register
+
+func (r registers) MarshalJSON() ([]byte, error) {
+	b := []byte{'{'}
+	for i, reg := range r {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, fmt.Sprintf("%q:%q", reg.Name, reg.Value)...)
+	}
+	return append(b, '}'), nil
+}
+
+type processInfo struct {
+	Pid   int    `json:"pid"`
+	Ppid  int    `json:"ppid"`
+	Pgrp  int    `json:"pgrp"`
+	Sid   int    `json:"sid"`
+	Uid   int    `json:"uid"`
+	Gid   int    `json:"gid"`
+	State string `json:"state"`
+	Name  string `json:"name"`
+	Args  string `json:"args"`
+}
+
+type siginfoInfo struct {
+	Signal string `json:"signal"`
+	Errno  int    `json:"errno"`
+	Code   int    `json:"code"`
+	Addr   hex    `json:"addr"`
+}
+
+type threadInfo struct {
+	Tid       int          `json:"tid"`
+	Signal    string       `json:"signal,omitempty"`
+	Pending   hex          `json:"pending"`
+	Blocked   hex          `json:"blocked"`
+	Siginfo   *siginfoInfo `json:"siginfo,omitempty"`
+	Registers registers    `json:"registers"`
+}
+
+type auxvInfo struct {
+	Name  string `json:"name"`
+	Value hex    `json:"value"`
+}
+
+type fileInfo struct {
+	Start  hex    `json:"start"`
+	End    hex    `json:"end"`
+	Offset hex    `json:"offset"`
+	Path   string `json:"path"`
+}
+
+type segmentInfo struct {
+	Vaddr  hex    `json:"vaddr"`
+	Memsz  uint64 `json:"memsz"`
+	Filesz uint64 `json:"filesz"`
+	Flags  string `json:"flags"`
+	Reason string `json:"reason,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+type faultInfo struct {
+	Start hex    `json:"start"`
+	End   hex    `json:"end"`
+	Error string `json:"error,omitempty"`
+}
+
+type coreInfo struct {
+	Process       *processInfo       `json:"process,omitempty"`
+	Metadata      *pkgnotes.Metadata `json:"metadata,omitempty"`
+	Missing       []pkgnotes.Missing `json:"missing,omitempty"`
+	Threads       []threadInfo       `json:"threads"`
+	Auxv          []auxvInfo         `json:"auxv"`
+	Files         []fileInfo         `json:"files"`
+	Segments      []segmentInfo      `json:"segments"`
+	Captured      uint64             `json:"captured"`
+	Omitted       uint64             `json:"omitted"`
+	Faults        []faultInfo        `json:"faults,omitempty"`
+	FaultsDropped uint64             `json:"faultsDropped,omitempty"`
+}
+
+func infoMain(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "print JSON rather than text")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s info [-json] core\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	f, err := core.NewFile(file)
+	if err != nil {
+		return err
+	}
+
+	info := summarize(f, file)
+
+	if *jsonOutput {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(info)
+	}
+
+	return info.write(os.Stdout)
+}
+
+func signalName(sig int) string {
+	if name := unix.SignalName(syscall.Signal(sig)); name != "" {
+		return name
+	}
+	return fmt.Sprintf("signal %d", sig)
+}
+
+// summarize describes core f, read from r.  If r is a regular file, only the
+// segments' data is counted as captured, not the holes which gcore leaves for
+// pages it didn't read or found to be zero.
+func summarize(f *core.File, r io.ReaderAt) *coreInfo {
+	info := &coreInfo{}
+
+	if p := f.Process; p != nil {
+		info.Process = &processInfo{
+			Pid:   p.Pid,
+			Ppid:  p.Ppid,
+			Pgrp:  p.Pgrp,
+			Sid:   p.Sid,
+			Uid:   p.Uid,
+			Gid:   p.Gid,
+			State: string(p.State),
+			Name:  p.Name,
+			Args:  strings.TrimRight(p.Args, " "),
+		}
+	}
+
+	info.Metadata = f.Metadata
+	info.Missing = f.Missing
+
+	for _, t := range f.Threads {
+		ti := threadInfo{
+			Tid:     t.Tid,
+			Pending: hex(t.Sigpend),
+			Blocked: hex(t.Sighold),
+		}
+
+		if t.Cursig != 0 {
+			ti.Signal = signalName(t.Cursig)
+		}
+
+		if si := t.Siginfo; si != nil && si.Signo != 0 {
+			ti.Siginfo = &siginfoInfo{
+				Signal: signalName(int(si.Signo)),
+				Errno:  int(si.Errno),
+				Code:   int(si.Code),
+				Addr:   hex(si.Addr()),
+			}
+		}
+
+		v := reflect.ValueOf(t.Regs)
+		for i := 0; i < v.NumField(); i++ {
+			ti.Registers = append(ti.Registers, register{
+				Name:  strings.ToLower(v.Type().Field(i).Name),
+				Value: hex(v.Field(i).Uint()),
+			})
+		}
+
+		info.Threads = append(info.Threads, ti)
+	}
+
+	for _, e := range f.Auxv {
+		info.Auxv = append(info.Auxv, auxvInfo{Name: e.Name(), Value: hex(e.Val)})
+	}
+
+	for _, m := range f.Files {
+		info.Files = append(info.Files, fileInfo{Start: hex(m.Start), End: hex(m.End), Offset: hex(m.Offset), Path: m.Path})
+	}
+
+	regions := make(map[uint64]*pkgnotes.Region, len(f.Regions))
+	for i := range f.Regions {
+		if _, ok := regions[f.Regions[i].Start]; !ok {
+			regions[f.Regions[i].Start] = &f.Regions[i]
+		}
+	}
+
+	for _, prog := range f.ELF.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+
+		si := segmentInfo{
+			Vaddr:  hex(prog.Vaddr),
+			Memsz:  prog.Memsz,
+			Filesz: prog.Filesz,
+			Flags:  flags(prog.Flags),
+		}
+
+		if r := regions[prog.Vaddr]; r != nil {
+			si.Reason = r.Reason.String()
+			si.Path = r.Pathname
+		}
+
+		captured := prog.Filesz
+		if file, ok := r.(*os.File); ok {
+			if n, err := dataBytes(file, int64(prog.Off), int64(prog.Filesz)); err == nil {
+				captured = uint64(n)
+			}
+		}
+
+		info.Segments = append(info.Segments, si)
+		info.Captured += captured
+		info.Omitted += prog.Memsz - captured
+	}
+
+	for _, fault := range f.Faults {
+		fi := faultInfo{Start: hex(fault.Start), End: hex(fault.End)}
+		if fault.Err != nil {
+			fi.Error = fault.Err.Error()
+		}
+		info.Faults = append(info.Faults, fi)
+	}
+	info.FaultsDropped = f.FaultsDropped
+
+	return info
+}
+
+// dataBytes returns how many of the n bytes at off in f are data rather than
+// holes, according to SEEK_DATA and SEEK_HOLE.
+func dataBytes(f *os.File, off, n int64) (int64, error) {
+	var total int64
+
+	for end := off + n; off < end; {
+		data, err := f.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break // only holes remain
+		}
+		if err != nil {
+			return 0, err
+		}
+		if data >= end {
+			break
+		}
+
+		hole, err := f.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return 0, err
+		}
+
+		total += min(hole, end) - data
+		off = hole
+	}
+
+	return total, nil
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func flags(f elf.ProgFlag) string {
+	b := []byte("---")
+	if f&elf.PF_R != 0 {
+		b[0] = 'r'
+	}
+	if f&elf.PF_W != 0 {
+		b[1] = 'w'
+	}
+	if f&elf.PF_X != 0 {
+		b[2] = 'x'
+	}
+	return string(b)
+}
+
+func (info *coreInfo) write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	if p := info.Process; p != nil {
+		fmt.Fprintf(tw, "Process %d (%s), state %s\n", p.Pid, p.Name, p.State)
+		fmt.Fprintf(tw, "  args: %s\n", p.Args)
+		fmt.Fprintf(tw, "  ppid %d, pgrp %d, sid %d, uid %d, gid %d\n", p.Ppid, p.Pgrp, p.Sid, p.Uid, p.Gid)
+	}
+
+	if m := info.Metadata; m != nil {
+		fmt.Fprintf(tw, "\nMetadata\n")
+		fmt.Fprintf(tw, "  pid %d, nspid %v\n", m.Pid, m.NSpid)
+		if m.ContainerID != "" {
+			fmt.Fprintf(tw, "  container: %s\n", m.ContainerID)
+		}
+		for _, cg := range m.Cgroups {
+			fmt.Fprintf(tw, "  cgroup %d:%s:%s\n", cg.ID, strings.Join(cg.Controllers, ","), cg.Path)
+		}
+		for _, name := range sortedKeys(m.Namespaces) {
+			fmt.Fprintf(tw, "  ns %s\t%d\n", name, m.Namespaces[name])
+		}
+		for _, mf := range m.Files {
+			fmt.Fprintf(tw, "  file %s\t%d\t%s\t%s\n", mf.Path, mf.Size, mf.BuildID, mf.Err)
+		}
+	}
+
+	if len(info.Missing) > 0 {
+		fmt.Fprintf(tw, "\nMissing notes\n")
+		for _, m := range info.Missing {
+			what := m.Type
+			if m.Tid != 0 {
+				what += fmt.Sprintf(" (thread %d)", m.Tid)
+			}
+			if m.Partial {
+				what += ", partial"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\n", what, m.Err)
+		}
+	}
+
+	for _, t := range info.Threads {
+		fmt.Fprintf(tw, "\nThread %d\n", t.Tid)
+		if t.Signal != "" {
+			fmt.Fprintf(tw, "  signal: %s\n", t.Signal)
+		}
+		fmt.Fprintf(tw, "  pending: %016x, blocked: %016x\n", uint64(t.Pending), uint64(t.Blocked))
+		if si := t.Siginfo; si != nil {
+			fmt.Fprintf(tw, "  siginfo: %s, code %d, errno %d, addr %s\n", si.Signal, si.Code, si.Errno, si.Addr)
+		}
+		for i, reg := range t.Registers {
+			fmt.Fprintf(tw, "  %s\t%#016x\t", reg.Name, uint64(reg.Value))
+			if i%3 == 2 || i == len(t.Registers)-1 {
+				fmt.Fprintln(tw)
+			}
+		}
+	}
+
+	if len(info.Auxv) > 0 {
+		fmt.Fprintf(tw, "\nAuxv\n")
+		for _, e := range info.Auxv {
+			fmt.Fprintf(tw, "  %s\t%s\n", e.Name, e.Value)
+		}
+	}
+
+	if len(info.Files) > 0 {
+		fmt.Fprintf(tw, "\nFiles\n")
+		fmt.Fprintf(tw, "  START\tEND\tOFFSET\tPATH\n")
+		for _, m := range info.Files {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", m.Start, m.End, m.Offset, m.Path)
+		}
+	}
+
+	fmt.Fprintf(tw, "\nSegments\n")
+	fmt.Fprintf(tw, "  VADDR\tMEMSZ\tFILESZ\tFLAGS\tREASON\tPATH\n")
+	for _, s := range info.Segments {
+		fmt.Fprintf(tw, "  %s\t%#x\t%#x\t%s\t%s\t%s\n", s.Vaddr, s.Memsz, s.Filesz, s.Flags, s.Reason, s.Path)
+	}
+	fmt.Fprintf(tw, "  captured %d bytes, omitted %d bytes\n", info.Captured, info.Omitted)
+
+	if len(info.Faults) > 0 {
+		fmt.Fprintf(tw, "\nFaults\n")
+		for _, f := range info.Faults {
+			fmt.Fprintf(tw, "  %s-%s\t%s\n", f.Start, f.End, f.Error)
+		}
+		if info.FaultsDropped > 0 {
+			fmt.Fprintf(tw, "  %d more not recorded\n", info.FaultsDropped)
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jim-minter/gcore/pkg/core"
+	"github.com/jim-minter/gcore/pkg/gcore"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+func startSleep(t *testing.T) int {
+	cmd := exec.Command("sleep", "60")
+
+	err := cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for {
+		stat, err := proc.ReadStat(cmd.Process.Pid, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stat.Comm == "sleep" && stat.State == 'S' {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestSummarize(t *testing.T) {
+	pid := startSleep(t)
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "core"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	_, err = gcore.Dump(context.Background(), pid, file, gcore.Options{Resident: true})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := core.NewFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := summarize(f, file)
+
+	if info.Process == nil || info.Process.Pid != pid || info.Process.Name != "sleep" {
+		t.Errorf("got process %+v", info.Process)
+	}
+	if len(info.Threads) != 1 || info.Threads[0].Tid != pid {
+		t.Errorf("got threads %+v", info.Threads)
+	}
+
+	var memsz, filesz uint64
+	for _, s := range info.Segments {
+		memsz += s.Memsz
+		filesz += s.Filesz
+	}
+
+	if info.Captured+info.Omitted != memsz {
+		t.Errorf("got captured %d + omitted %d, want %d", info.Captured, info.Omitted, memsz)
+	}
+
+	// The untouched pages of sleep's stack, at least, are holes.
+	if info.Captured == 0 || info.Captured >= filesz {
+		t.Errorf("got captured %d, want less than filesz %d", info.Captured, filesz)
+	}
+
+	// The same core read other than from a file counts holes as captured.
+	b, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info := summarize(f, bytes.NewReader(b)); info.Captured != filesz {
+		t.Errorf("got captured %d from memory, want %d", info.Captured, filesz)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+// extern int pid;
+import "C"
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jim-minter/gcore/pkg/stack"
+)
+
+// pstackFlags are the flags of gcore pstack.
+type pstackFlags struct {
+	verbose *bool
+}
+
+func addPstackFlags(fs *flag.FlagSet) *pstackFlags {
+	f := &pstackFlags{}
+	f.verbose = fs.Bool("v", false, "report how long the target was stopped for")
+	addAllFlag(fs)
+	return f
+}
+
+func pstackMain(args []string) error {
+	fs := flag.NewFlagSet("pstack", flag.ExitOnError)
+	f := addPstackFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s pstack [-v] pid\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	result, err := stack.Trace(int(C.pid))
+	if err != nil {
+		return err
+	}
+
+	for i, t := range result.Threads {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Printf("Thread %d (%s):\n", t.Tid, t.Name)
+		for j, f := range t.Frames {
+			fmt.Printf("#%-2d %s\n", j, &f)
+		}
+		if t.Err != nil {
+			fmt.Printf("    unwinding stopped: %v\n", t.Err)
+		}
+	}
+
+	if *f.verbose {
+		fmt.Fprintf(os.Stderr, "stopped for %v\n", result.Pause)
+	}
+
+	return nil
+}
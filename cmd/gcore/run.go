@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/jim-minter/gcore/pkg/gcore"
+	"github.com/jim-minter/gcore/pkg/ptrace"
+)
+
+func runMain(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	df := addDumpFlags(fs)
+	output := fs.String("o", "core", "write cores to `prefix`.<pid>.<n>")
+	demand := fs.String("signal", "SIGUSR1", "dump the command whenever gcore receives `signal`")
+	timeout := fs.Duration("timeout", 0, "dump the command and kill it if it is still running after `duration`")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s run [options] -- command [args...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	sig, err := parseSignal(*demand)
+	if err != nil {
+		return err
+	}
+
+	r := &runner{
+		df:      df,
+		output:  *output,
+		demand:  sig,
+		timeout: *timeout,
+	}
+
+	status, err := r.run(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	// Exit as the command did, as a shell would report it.
+	if status.Signaled() {
+		os.Exit(128 + int(status.Signal()))
+	}
+	os.Exit(status.ExitStatus())
+
+	return nil
+}
+
+// A runner starts a command traced from its first instruction and dumps it
+// when it is about to crash, when gcore receives the demand signal, or on
+// timeout.
+type runner struct {
+	df      *dumpFlags
+	output  string
+	demand  syscall.Signal
+	timeout time.Duration
+
+	pid int
+	n   int
+}
+
+func (r *runner) run(args []string) (_ unix.WaitStatus, err error) {
+	_, err = exec.LookPath(args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer pw.Close()
+
+	// The stub in gcore.c waits on the pipe, inherited as fd 3, then execs
+	// args.
+	cmd := &exec.Cmd{
+		Path:       self,
+		Args:       args,
+		Env:        append(os.Environ(), "GCORE_RUN_FD=3"),
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		ExtraFiles: []*os.File{pr},
+	}
+
+	err = cmd.Start()
+	pr.Close()
+	if err != nil {
+		return 0, err
+	}
+	r.pid = cmd.Process.Pid
+
+	s, err := ptrace.Seize(r.pid)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	defer s.Detach()
+
+	_, err = pw.Write([]byte{0})
+	if err != nil {
+		return 0, err
+	}
+
+	demand := make(chan os.Signal, 1)
+	signal.Notify(demand, r.demand)
+	defer signal.Stop(demand)
+
+	// Signals which would otherwise stop gcore are caught.  The command shares
+	// gcore's process group, so a SIGINT from the terminal already reaches it
+	// and is swallowed here; SIGTERM, typically sent to gcore alone, is passed
+	// on.
+	forward := make(chan os.Signal, 1)
+	signal.Notify(forward, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(forward)
+
+	go func() {
+		for sig := range forward {
+			if sig == syscall.SIGTERM {
+				syscall.Kill(r.pid, syscall.SIGTERM)
+			}
+		}
+	}()
+
+	var timer <-chan time.Time
+	if r.timeout > 0 {
+		timer = time.After(r.timeout)
+	}
+
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		why := make(chan string, 1)
+		done := make(chan struct{})
+
+		go func() {
+			select {
+			case <-demand:
+				why <- "demand"
+			case <-timer:
+				why <- "timeout"
+			case <-done:
+				return
+			}
+			cancel()
+		}()
+
+		tid, err := s.WaitSignal(ctx, func(tid int, sig unix.Signal) bool {
+			return gcore.IsFatal(r.pid, sig)
+		})
+		close(done)
+		cancel()
+
+		var exitErr *ptrace.ExitError
+		switch {
+		case err == nil:
+			err = r.dump(s, fmt.Sprintf("%s in thread %d", unix.SignalName(s.Signal(tid)), tid))
+			if err != nil {
+				return 0, err
+			}
+
+			// Let the signal take its course.
+			err = s.Detach()
+			if err != nil {
+				return 0, err
+			}
+			return wait(cmd)
+
+		case errors.As(err, &exitErr):
+			return exitErr.Status, nil
+
+		case errors.Is(err, context.Canceled) && <-why == "demand":
+			err = r.dump(s, "on demand")
+			if err != nil {
+				return 0, err
+			}
+
+		case errors.Is(err, context.Canceled):
+			err = r.dump(s, "timed out")
+			if err != nil {
+				return 0, err
+			}
+
+			// The threads are still traced, so must be reaped here as they
+			// die.
+			cmd.Process.Kill()
+			_, err = s.WaitSignal(context.Background(), func(int, unix.Signal) bool { return false })
+			if errors.As(err, &exitErr) {
+				return exitErr.Status, nil
+			}
+			return 0, err
+
+		default:
+			return 0, err
+		}
+	}
+}
+
+// dump writes a core of the stopped command to the next output file.
+func (r *runner) dump(s *ptrace.Session, reason string) error {
+	r.n++
+	name := fmt.Sprintf("%s.%d.%d", r.output, r.pid, r.n)
+
+	err := r.df.writeCore(name, func(w io.Writer) error {
+		result, err := gcore.DumpSession(context.Background(), s, w, r.df.options())
+		if err != nil {
+			return err
+		}
+
+		for _, f := range result.Faults {
+			fmt.Fprintf(os.Stderr, "warning: couldn't read %#x-%#x: %v\n", f.Start, f.End, f.Err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "gcore: wrote %s (%s)\n", name, reason)
+
+	return nil
+}
+
+func wait(cmd *exec.Cmd) (unix.WaitStatus, error) {
+	err := cmd.Wait()
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return 0, err
+	}
+
+	return unix.WaitStatus(cmd.ProcessState.Sys().(syscall.WaitStatus)), nil
+}
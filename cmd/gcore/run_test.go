@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/jim-minter/gcore/pkg/core"
+)
+
+// buildGcore builds gcore itself, as gcore run re-executes its own binary to
+// start the command.
+func buildGcore(t *testing.T) string {
+	exe := filepath.Join(t.TempDir(), "gcore")
+
+	out, err := exec.Command("go", "build", "-o", exe, ".").CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	return exe
+}
+
+// runCommand runs gcore run with args, writing cores to dir, and returns the
+// exit status and stderr.
+func runCommand(t *testing.T, dir string, args ...string) (int, string) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(buildGcore(t), append([]string{"run", "-o", filepath.Join(dir, "core")}, args...)...)
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(stderr.String(), syscall.EPERM.Error()) {
+		t.Skip(stderr.String())
+	}
+
+	return cmd.ProcessState.ExitCode(), stderr.String()
+}
+
+// readCore opens the one core in dir, checking that it was reported on stderr.
+func readCore(t *testing.T, dir, stderr, reason string) *core.File {
+	names, err := filepath.Glob(filepath.Join(dir, "core.*.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("got cores %v, stderr %q", names, stderr)
+	}
+
+	if want := fmt.Sprintf("gcore: wrote %s (%s", names[0], reason); !strings.Contains(stderr, want) {
+		t.Errorf("got stderr %q, want %q", stderr, want)
+	}
+
+	file, err := os.Open(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	f, err := core.NewFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestRunCrash(t *testing.T) {
+	dir := t.TempDir()
+
+	code, stderr := runCommand(t, dir, "--", "sh", "-c", "kill -SEGV $$")
+	if code != 128+int(syscall.SIGSEGV) {
+		t.Errorf("got exit status %d, want %d; stderr %q", code, 128+int(syscall.SIGSEGV), stderr)
+	}
+
+	f := readCore(t, dir, stderr, "SIGSEGV in thread")
+
+	if len(f.Threads) != 1 || f.Threads[0].Cursig != int(syscall.SIGSEGV) {
+		t.Errorf("got threads %+v, want one with SIGSEGV", f.Threads)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	dir := t.TempDir()
+
+	code, stderr := runCommand(t, dir, "-timeout", "500ms", "--", "sleep", "60")
+	if code != 128+int(syscall.SIGKILL) {
+		t.Errorf("got exit status %d, want %d; stderr %q", code, 128+int(syscall.SIGKILL), stderr)
+	}
+
+	f := readCore(t, dir, stderr, "timed out")
+
+	if f.Process == nil || f.Process.Name != "sleep" {
+		t.Errorf("got process %+v, want sleep", f.Process)
+	}
+}
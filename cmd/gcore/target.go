@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/jim-minter/gcore/pkg/cri"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+// Targets other than a pid, e.g. name:regex, are resolved to pids here, in
+// gcore's own namespaces, and gcore then runs itself again with each pid, so
+// that the C constructor can join the process's namespaces as usual.
+
+// targetSubcommands take a target as their last argument.  Each registers the
+// subcommand's flags, so that runTargets can parse its arguments.
+var targetSubcommands = map[string]func(*flag.FlagSet){
+	"":           func(fs *flag.FlagSet) { addMainFlags(fs) },
+	"pstack":     func(fs *flag.FlagSet) { addPstackFlags(fs) },
+	"watch":      func(fs *flag.FlagSet) { addWatchFlags(fs) },
+	"goroutines": func(fs *flag.FlagSet) { addGoroutinesFlags(fs) },
+}
+
+var targetKinds = []string{"cgroup", "container", "cri", "name", "cmdline"}
+
+// outputSuffix, if set, is appended to output paths by writeCore, so that the
+// processes of a target with -all are written to different files.
+var outputSuffix string
+
+func init() {
+	outputSuffix = os.Getenv("GCORE_OUTPUT_SUFFIX")
+	os.Unsetenv("GCORE_OUTPUT_SUFFIX")
+}
+
+// addAllFlag registers -all, which runTargets finds by parsing the
+// subcommand's flags itself, before resolving the target.
+func addAllFlag(fs *flag.FlagSet) {
+	fs.Bool("all", false, "when the target matches several processes, run for each of them rather than failing")
+}
+
+// targetArgs returns the subcommand and arguments, and whether the last
+// argument is a target other than a pid.
+func targetArgs() (sub string, args []string, ok bool) {
+	args = os.Args[1:]
+	if len(args) > 0 {
+		if _, ok := subcommands[args[0]]; ok {
+			sub, args = args[0], args[1:]
+		}
+	}
+
+	if _, ok := targetSubcommands[sub]; !ok || len(args) == 0 {
+		return "", nil, false
+	}
+
+	kind, _, found := strings.Cut(args[len(args)-1], ":")
+	if !found {
+		return "", nil, false
+	}
+
+	for _, k := range targetKinds {
+		if kind == k {
+			return sub, args, true
+		}
+	}
+
+	// goroutines also takes a core, whose path may contain a colon.  Other
+	// subcommands report the invalid target.
+	return sub, args, sub != "goroutines"
+}
+
+// resolve returns the pids of the processes which target selects:
+//
+//   - cgroup:path: the main processes of a cgroup v2 directory, i.e. those
+//     whose parents aren't in it too.
+//   - container:id: the main process of the container whose ID starts with id,
+//     as found in /proc/<pid>/cgroup.
+//   - cri:id: the main process of the container whose ID starts with, or whose
+//     name is, id, as found through the CRI socket at
+//     $CONTAINER_RUNTIME_ENDPOINT, or containerd's by default.
+//   - name:regex and cmdline:regex: the processes whose names or command lines
+//     match regex, like pgrep(1) and pgrep -f.
+func resolve(ctx context.Context, target string) ([]int, error) {
+	kind, arg, _ := strings.Cut(target, ":")
+	if arg == "" {
+		return nil, fmt.Errorf("invalid target %q", target)
+	}
+
+	switch kind {
+	case "cgroup":
+		pids, err := proc.CgroupProcs(arg)
+		if err != nil {
+			return nil, err
+		}
+		return proc.Topmost(pids), nil
+
+	case "container":
+		pids, err := proc.ContainerProcs(arg)
+		if err != nil {
+			return nil, err
+		}
+		return proc.Topmost(pids), nil
+
+	case "cri":
+		endpoint := os.Getenv("CONTAINER_RUNTIME_ENDPOINT")
+		if endpoint == "" {
+			endpoint = cri.DefaultEndpoint
+		}
+
+		c, err := cri.NewClient(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+
+		containers, err := c.Find(ctx, arg)
+		if err != nil {
+			return nil, err
+		}
+
+		var pids []int
+		for _, ct := range containers {
+			pid, err := c.Pid(ctx, ct.ID)
+			if err != nil {
+				return nil, err
+			}
+			pids = append(pids, pid)
+		}
+		return pids, nil
+
+	case "name", "cmdline":
+		rx, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return proc.MatchProcs(rx, kind == "cmdline")
+	}
+
+	return nil, fmt.Errorf("invalid target %q", target)
+}
+
+// hasAll reports whether args, the options of subcommand sub, set -all.  They
+// are parsed with the subcommand's own flags, so that e.g. the value of -o
+// isn't mistaken for -all.
+func hasAll(sub string, args []string) (bool, error) {
+	fs := flag.NewFlagSet(sub, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	targetSubcommands[sub](fs)
+
+	err := fs.Parse(args)
+	if err != nil {
+		return false, err
+	}
+
+	return fs.Lookup("all").Value.String() == "true", nil
+}
+
+// runTargets resolves the target which is the last of args, and runs gcore
+// again with each pid found in its place, in turn.  It returns the exit status.
+func runTargets(sub string, args []string) int {
+	target := args[len(args)-1]
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	all, err := hasAll(sub, args[:len(args)-1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	pids, err := resolve(ctx, target)
+	if err == nil {
+		err = checkTargets(sub, target, pids, all)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// When gcore joins a pid namespace, it forks and carries on in the
+	// child.  Reaping that too means waiting for it and getting its status.
+	err = unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Signals are passed on to the gcore running at the time.
+	var running atomic.Int64
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range sigs {
+			if pid := running.Load(); pid != 0 {
+				syscall.Kill(int(pid), sig.(syscall.Signal))
+			}
+		}
+	}()
+
+	var failed int
+	for _, pid := range pids {
+		if ctx.Err() != nil {
+			break
+		}
+
+		cmdArgs := append([]string{}, args[:len(args)-1]...)
+		if sub != "" {
+			cmdArgs = append([]string{sub}, cmdArgs...)
+		}
+
+		cmd := exec.Command("/proc/self/exe", append(cmdArgs, strconv.Itoa(pid))...)
+		cmd.Args[0] = os.Args[0]
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if len(pids) > 1 {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", target, describe(pid))
+			cmd.Env = append(os.Environ(), "GCORE_OUTPUT_SUFFIX=."+strconv.Itoa(pid))
+		}
+
+		var code int
+
+		err := cmd.Start()
+		if err == nil {
+			running.Store(int64(cmd.Process.Pid))
+			err = cmd.Wait()
+			running.Store(0)
+		}
+
+		var exitErr *exec.ExitError
+		switch {
+		case errors.As(err, &exitErr):
+			code = exitErr.ExitCode()
+		case err != nil:
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+
+		if c, err := reapAll(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		} else if c != 0 {
+			code = c
+		}
+
+		if code != 0 {
+			if len(pids) == 1 {
+				if code < 0 {
+					code = 1
+				}
+				return code
+			}
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d processes failed\n", failed, len(pids))
+		return 1
+	}
+
+	return 0
+}
+
+// checkTargets rejects a target matching no processes, or several, unless all
+// is set and the subcommand can run for each.
+func checkTargets(sub, target string, pids []int, all bool) error {
+	switch {
+	case len(pids) == 0:
+		return fmt.Errorf("no process matches %s", target)
+
+	case len(pids) == 1:
+		return nil
+
+	case !all || sub == "watch":
+		var matches []string
+		for _, pid := range pids {
+			matches = append(matches, describe(pid))
+		}
+
+		err := fmt.Errorf("%s matches %d processes: %s", target, len(pids), strings.Join(matches, ", "))
+		if sub != "watch" {
+			err = fmt.Errorf("%w; use -all to select them all", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// reapAll waits for the children reparented to us, i.e. a gcore which forked
+// on joining a pid namespace, and returns the exit status of the last to fail.
+func reapAll() (code int, err error) {
+	for {
+		var ws unix.WaitStatus
+		_, err := unix.Wait4(-1, &ws, 0, nil)
+		switch err {
+		case nil:
+		case unix.EINTR:
+			continue
+		case unix.ECHILD:
+			return code, nil
+		default:
+			return code, err
+		}
+
+		switch {
+		case ws.Exited() && ws.ExitStatus() != 0:
+			code = ws.ExitStatus()
+		case ws.Signaled():
+			code = 128 + int(ws.Signal())
+		}
+	}
+}
+
+// describe returns pid and its name, e.g. "1234 (sleep)".
+func describe(pid int) string {
+	stat, err := proc.ReadStat(pid, 0)
+	if err != nil {
+		return strconv.Itoa(pid)
+	}
+
+	return fmt.Sprintf("%d (%s)", pid, stat.Comm)
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestHasAll(t *testing.T) {
+	for _, tt := range []struct {
+		sub     string
+		args    []string
+		want    bool
+		wantErr bool
+	}{
+		{sub: "", args: nil},
+		{sub: "", args: []string{"-all"}, want: true},
+		{sub: "", args: []string{"--all=true"}, want: true},
+		{sub: "", args: []string{"-all=false"}},
+		{sub: "", args: []string{"-o", "all"}},
+		{sub: "", args: []string{"-o=all"}},
+		{sub: "", args: []string{"-o", "core", "-all"}, want: true},
+		{sub: "", args: []string{"-catch", "-all"}, want: true},
+		{sub: "pstack", args: []string{"-v", "-all"}, want: true},
+		{sub: "goroutines", args: []string{"-exe", "all"}},
+		{sub: "watch", args: []string{"-signal", "all", "-n", "2"}},
+		{sub: "watch", args: []string{"-rss", "1G", "-all"}, want: true},
+		{sub: "", args: []string{"-bogus"}, wantErr: true},
+		{sub: "pstack", args: []string{"-o", "all"}, wantErr: true},
+	} {
+		got, err := hasAll(tt.sub, tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("hasAll(%q, %q): got error %v", tt.sub, tt.args, err)
+		}
+		if got != tt.want {
+			t.Errorf("hasAll(%q, %q) = %v, want %v", tt.sub, tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+// extern int pid;
+import "C"
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/jim-minter/gcore/pkg/gcore"
+	"github.com/jim-minter/gcore/pkg/ptrace"
+	"github.com/jim-minter/gcore/pkg/trigger"
+)
+
+// sizeFlag is a size in bytes, optionally suffixed K, M, G or T.
+type sizeFlag uint64
+
+func (f *sizeFlag) String() string {
+	return strconv.FormatUint(uint64(*f), 10)
+}
+
+func (f *sizeFlag) Set(s string) error {
+	s = strings.ToUpper(s)
+
+	var shift uint
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i != -1 {
+			shift, s = 10*uint(i+1), s[:n-1]
+		}
+	}
+
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	*f = sizeFlag(v << shift)
+	return nil
+}
+
+// parseSignal parses a signal name, with or without its SIG prefix.
+func parseSignal(name string) (syscall.Signal, error) {
+	s := strings.ToUpper(name)
+	if !strings.HasPrefix(s, "SIG") {
+		s = "SIG" + s
+	}
+
+	sig := unix.SignalNum(s)
+	if sig == 0 {
+		return 0, fmt.Errorf("invalid signal %q", name)
+	}
+
+	return sig, nil
+}
+
+// watchFlags are the flags of gcore watch.
+type watchFlags struct {
+	*dumpFlags
+	conditions trigger.Conditions
+	rss        sizeFlag
+	sigName    *string
+	output     *string
+	max        *int
+	cooldown   *time.Duration
+	interval   *time.Duration
+}
+
+func addWatchFlags(fs *flag.FlagSet) *watchFlags {
+	f := &watchFlags{dumpFlags: addDumpFlags(fs)}
+	fs.Float64Var(&f.conditions.CPU, "cpu", 0, "dump when CPU usage exceeds `percent` of one CPU")
+	fs.DurationVar(&f.conditions.CPUFor, "cpu-for", 0, "and has done so for `duration`")
+	fs.Var(&f.rss, "rss", "dump when the resident set exceeds `size` (e.g. 512M)")
+	fs.IntVar(&f.conditions.Threads, "threads", 0, "dump when the thread count exceeds `n`")
+	f.sigName = fs.String("signal", "", "dump when the target is about to handle `signal` (traces the target throughout)")
+	f.output = fs.String("o", "core", "write cores to `prefix`.<pid>.<n>")
+	f.max = fs.Int("n", 1, "write at most `n` cores")
+	f.cooldown = fs.Duration("cooldown", 10*time.Second, "wait `duration` after a dump before checking again")
+	f.interval = fs.Duration("interval", time.Second, "sample the target every `duration`")
+	addAllFlag(fs)
+	return f
+}
+
+func watchMain(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	f := addWatchFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s watch [options] pid\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	c := f.conditions
+	c.RSS = uint64(f.rss)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if c.CPU == 0 && c.RSS == 0 && c.Threads == 0 && *f.sigName == "" {
+		return errors.New("no condition given: set at least one of -cpu, -rss, -threads or -signal")
+	}
+
+	w := &watcher{
+		df:       f.dumpFlags,
+		pid:      int(C.pid),
+		output:   *f.output,
+		max:      *f.max,
+		cooldown: *f.cooldown,
+	}
+
+	if *f.sigName != "" {
+		var err error
+		w.sig, err = parseSignal(*f.sigName)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	reasons := make(chan string)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- w.poll(ctx, trigger.New(c), *f.interval, reasons)
+	}()
+
+	if w.sig != 0 {
+		return w.trace(ctx, reasons, errs)
+	}
+
+	return w.watch(ctx, reasons, errs)
+}
+
+// A watcher dumps a process up to max times, as conditions are met.
+type watcher struct {
+	df       *dumpFlags
+	pid      int
+	output   string
+	max      int
+	cooldown time.Duration
+	sig      syscall.Signal
+
+	n     int
+	until time.Time // the end of the cooldown
+}
+
+// poll samples the target every interval until ctx is done, sending why on
+// reasons whenever trig fires outside a cooldown.
+func (w *watcher) poll(ctx context.Context, trig *trigger.Trigger, interval time.Duration, reasons chan<- string) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		s, err := trigger.Read(w.pid)
+		if err != nil {
+			return err
+		}
+
+		if reason := trig.Check(s); reason != "" {
+			select {
+			case reasons <- reason:
+				trig.Reset()
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// watch dumps the target whenever the poller fires.
+func (w *watcher) watch(ctx context.Context, reasons <-chan string, errs <-chan error) error {
+	for w.n < w.max {
+		select {
+		case reason := <-reasons:
+			if time.Now().Before(w.until) {
+				continue
+			}
+
+			err := w.dump(reason, func(wr io.Writer) (*gcore.Result, error) {
+				return gcore.Dump(ctx, w.pid, wr, w.df.options())
+			})
+			if err != nil {
+				return err
+			}
+
+		case err := <-errs:
+			return err
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// trace keeps the target seized so that it can be dumped when it is about to
+// handle w.sig, as well as whenever the poller fires.
+func (w *watcher) trace(ctx context.Context, reasons <-chan string, errs <-chan error) (err error) {
+	s, err := ptrace.Seize(w.pid)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if derr := s.Detach(); err == nil {
+			err = derr
+		}
+	}()
+
+	// After a dump the thread still holds the signal, which must then be
+	// delivered rather than trigger another dump.
+	var skip int
+
+	for w.n < w.max {
+		wctx, cancel := context.WithCancel(ctx)
+		why := make(chan string, 1)
+		done := make(chan struct{})
+
+		go func() {
+			for {
+				select {
+				case reason := <-reasons:
+					if time.Now().Before(w.until) {
+						continue
+					}
+					why <- reason
+					cancel()
+				case <-done:
+				}
+				return
+			}
+		}()
+
+		tid, err := s.WaitSignal(wctx, func(tid int, sig unix.Signal) bool {
+			if tid == skip {
+				skip = 0
+				return false
+			}
+			return sig == w.sig && !time.Now().Before(w.until)
+		})
+		close(done)
+		cancel()
+
+		var reason string
+		switch {
+		case err == nil:
+			reason = fmt.Sprintf("thread %d received %s", tid, unix.SignalName(w.sig))
+			skip = tid
+
+		case errors.Is(err, context.Canceled) && ctx.Err() == nil:
+			select {
+			case reason = <-why:
+			default:
+				continue
+			}
+
+		case errors.Is(err, context.Canceled):
+			return nil
+
+		default:
+			return err
+		}
+
+		err = w.dump(reason, func(wr io.Writer) (*gcore.Result, error) {
+			return gcore.DumpSession(ctx, s, wr, w.df.options())
+		})
+		if err != nil {
+			return err
+		}
+
+		select {
+		case err := <-errs:
+			return err
+		default:
+		}
+	}
+
+	return nil
+}
+
+// dump writes the next core with dump, and starts the cooldown.
+func (w *watcher) dump(reason string, dump func(io.Writer) (*gcore.Result, error)) error {
+	w.n++
+	name := fmt.Sprintf("%s.%d.%d", w.output, w.pid, w.n)
+
+	fmt.Fprintf(os.Stderr, "gcore: %s, writing %s\n", reason, name)
+
+	err := w.df.writeCore(name, func(wr io.Writer) error {
+		result, err := dump(wr)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range result.Faults {
+			fmt.Fprintf(os.Stderr, "warning: couldn't read %#x-%#x: %v\n", f.Start, f.End, f.Err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.until = time.Now().Add(w.cooldown)
+
+	return nil
+}
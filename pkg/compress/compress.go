@@ -0,0 +1,243 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// chunkSize is the amount of uncompressed data handed to each worker.  Each
+// chunk becomes an independent gzip member, zstd frame or xz stream, all of
+// which standard decompressors read back as a single stream.
+const chunkSize = 4 << 20
+
+var Formats = []string{"gzip", "zstd", "zstd-seekable", "xz"}
+
+// DefaultLevel selects each format's default compression level.  Every other
+// level, including 0, is passed to the compressor as is.
+const DefaultLevel = -1
+
+type compressor func(src []byte) ([]byte, error)
+
+// NewWriter returns a writer which compresses to w in the given format.
+// DefaultLevel selects the format's default level.  Up to workers chunks are
+// compressed concurrently, in parallel with the caller's writes.  The returned
+// writer must be closed to flush it.
+func NewWriter(w io.Writer, format string, level, workers int) (io.WriteCloser, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var c compressor
+	var trailer func([]entry) []byte
+
+	switch format {
+	case "gzip":
+		if level == DefaultLevel {
+			level = gzip.DefaultCompression
+		}
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return nil, fmt.Errorf("invalid gzip level %d", level)
+		}
+		c = gzipCompressor(level)
+
+	case "zstd", "zstd-seekable":
+		if level == DefaultLevel {
+			level = 3
+		}
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(workers))
+		if err != nil {
+			return nil, err
+		}
+		c = func(src []byte) ([]byte, error) {
+			return enc.EncodeAll(src, nil), nil
+		}
+		if format == "zstd-seekable" {
+			trailer = seekTable
+		}
+
+	case "xz":
+		if level == DefaultLevel {
+			level = 6
+		}
+		if level < 0 || level >= len(xzDictCaps) {
+			return nil, fmt.Errorf("invalid xz level %d", level)
+		}
+		c = xzCompressor(xzDictCaps[level])
+
+	default:
+		return nil, fmt.Errorf("unsupported compression format %q", format)
+	}
+
+	return newParallelWriter(w, c, trailer, workers), nil
+}
+
+func gzipCompressor(level int) compressor {
+	return func(src []byte) ([]byte, error) {
+		buf := &bytes.Buffer{}
+
+		gw, err := gzip.NewWriterLevel(buf, level)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = gw.Write(src)
+		if err != nil {
+			return nil, err
+		}
+
+		err = gw.Close()
+		return buf.Bytes(), err
+	}
+}
+
+// xzDictCaps are the dictionary sizes of xz(1)'s presets 0-9.
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+func xzCompressor(dictCap int) compressor {
+	return func(src []byte) ([]byte, error) {
+		buf := &bytes.Buffer{}
+
+		xw, err := xz.WriterConfig{DictCap: dictCap}.NewWriter(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = xw.Write(src)
+		if err != nil {
+			return nil, err
+		}
+
+		err = xw.Close()
+		return buf.Bytes(), err
+	}
+}
+
+type frame struct {
+	compressed   []byte
+	decompressed int
+	err          error
+}
+
+type entry struct {
+	compressed   int
+	decompressed int
+}
+
+// parallelWriter compresses chunks concurrently and writes the results to w in
+// order.
+type parallelWriter struct {
+	w       io.Writer
+	c       compressor
+	trailer func([]entry) []byte
+
+	buf     []byte
+	pending chan chan *frame
+	done    chan struct{}
+
+	mu      sync.Mutex
+	err     error
+	entries []entry
+}
+
+func newParallelWriter(w io.Writer, c compressor, trailer func([]entry) []byte, workers int) *parallelWriter {
+	pw := &parallelWriter{
+		w:       w,
+		c:       c,
+		trailer: trailer,
+		buf:     make([]byte, 0, chunkSize),
+		pending: make(chan chan *frame, workers),
+		done:    make(chan struct{}),
+	}
+
+	go pw.writeFrames()
+
+	return pw
+}
+
+func (pw *parallelWriter) writeFrames() {
+	defer close(pw.done)
+
+	for ch := range pw.pending {
+		f := <-ch
+
+		if pw.error() != nil {
+			continue
+		}
+
+		err := f.err
+		if err == nil {
+			_, err = pw.w.Write(f.compressed)
+		}
+
+		pw.mu.Lock()
+		pw.err = err
+		if err == nil {
+			pw.entries = append(pw.entries, entry{compressed: len(f.compressed), decompressed: f.decompressed})
+		}
+		pw.mu.Unlock()
+	}
+}
+
+func (pw *parallelWriter) error() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	return pw.err
+}
+
+func (pw *parallelWriter) submit() {
+	ch := make(chan *frame, 1)
+	pw.pending <- ch
+
+	go func(b []byte) {
+		compressed, err := pw.c(b)
+		ch <- &frame{compressed: compressed, decompressed: len(b), err: err}
+	}(pw.buf)
+
+	pw.buf = make([]byte, 0, chunkSize)
+}
+
+func (pw *parallelWriter) Write(b []byte) (n int, err error) {
+	if err := pw.error(); err != nil {
+		return 0, err
+	}
+
+	for len(b) > 0 {
+		m := copy(pw.buf[len(pw.buf):cap(pw.buf)], b)
+		pw.buf = pw.buf[:len(pw.buf)+m]
+		b = b[m:]
+		n += m
+
+		if len(pw.buf) == cap(pw.buf) {
+			pw.submit()
+		}
+	}
+
+	return n, nil
+}
+
+func (pw *parallelWriter) Close() error {
+	if len(pw.buf) > 0 {
+		pw.submit()
+	}
+
+	close(pw.pending)
+	<-pw.done
+
+	if err := pw.error(); err != nil {
+		return err
+	}
+
+	if pw.trailer != nil {
+		_, err := pw.w.Write(pw.trailer(pw.entries))
+		return err
+	}
+
+	return nil
+}
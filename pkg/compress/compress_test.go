@@ -0,0 +1,151 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestNewWriter(t *testing.T) {
+	data := make([]byte, 3*chunkSize+12345)
+	rand.New(rand.NewSource(0)).Read(data[:1<<16])
+
+	for _, format := range Formats {
+		for _, workers := range []int{1, 4} {
+			buf := &bytes.Buffer{}
+
+			w, err := NewWriter(buf, format, DefaultLevel, workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = w.Write(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = w.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var r io.Reader
+			switch format {
+			case "gzip":
+				r, err = gzip.NewReader(buf)
+			case "zstd", "zstd-seekable":
+				r, err = zstd.NewReader(bytes.NewReader(buf.Bytes()))
+			case "xz":
+				r, err = xz.NewReader(buf)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(format, err)
+			}
+
+			if !bytes.Equal(got, data) {
+				t.Errorf("%s, %d workers: round trip mismatch", format, workers)
+			}
+
+			if format == "zstd-seekable" {
+				testSeekTable(t, buf.Bytes(), 4, len(data))
+			}
+		}
+	}
+}
+
+func TestNewWriterLevelZero(t *testing.T) {
+	data := make([]byte, 1<<20)
+
+	for _, format := range []string{"gzip", "xz"} {
+		buf := &bytes.Buffer{}
+
+		w, err := NewWriter(buf, format, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = w.Write(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// gzip's level 0 stores the data uncompressed.
+		if format == "gzip" && buf.Len() < len(data) {
+			t.Errorf("gzip level 0: got %d bytes, want at least %d", buf.Len(), len(data))
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestParallelWriterError(t *testing.T) {
+	w, err := NewWriter(failingWriter{}, "zstd-seekable", DefaultLevel, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = w.Write(make([]byte, 2*chunkSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Close()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if entries := w.(*parallelWriter).entries; len(entries) != 0 {
+		t.Errorf("got %d seek table entries for failed writes", len(entries))
+	}
+}
+
+func testSeekTable(t *testing.T, b []byte, frames, size int) {
+	footer := b[len(b)-9:]
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		t.Fatal("missing seekable magic")
+	}
+
+	n := int(binary.LittleEndian.Uint32(footer))
+	if n != frames {
+		t.Fatalf("got %d frames, want %d", n, frames)
+	}
+
+	table := b[len(b)-9-n*8-8:]
+	if binary.LittleEndian.Uint32(table) != skippableFrameMagic {
+		t.Fatal("missing skippable frame magic")
+	}
+
+	var compressed, decompressed int
+	for i := 0; i < n; i++ {
+		compressed += int(binary.LittleEndian.Uint32(table[8+i*8:]))
+		decompressed += int(binary.LittleEndian.Uint32(table[12+i*8:]))
+	}
+
+	if compressed != len(b)-len(table) {
+		t.Errorf("got compressed size %d, want %d", compressed, len(b)-len(table))
+	}
+	if decompressed != size {
+		t.Errorf("got decompressed size %d, want %d", decompressed, size)
+	}
+}
@@ -0,0 +1,32 @@
+package compress
+
+import (
+	"encoding/binary"
+)
+
+// See https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+const (
+	skippableFrameMagic = 0x184D2A5E
+	seekableMagic       = 0x8F92EAB1
+)
+
+// seekTable returns the skippable frame which terminates a seekable zstd
+// stream, listing the compressed and decompressed size of each frame.
+// Checksums are omitted.
+func seekTable(entries []entry) []byte {
+	b := make([]byte, 8, 8+len(entries)*8+9)
+
+	for _, e := range entries {
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.compressed))
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.decompressed))
+	}
+
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(entries)))
+	b = append(b, 0) // Seek_Table_Descriptor: no checksums
+	b = binary.LittleEndian.AppendUint32(b, seekableMagic)
+
+	binary.LittleEndian.PutUint32(b[0:], skippableFrameMagic)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+
+	return b
+}
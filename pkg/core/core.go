@@ -0,0 +1,247 @@
+// Package core reads ELF core files, such as those written by gcore.
+package core
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	pkgnotes "github.com/jim-minter/gcore/pkg/notes"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+var (
+	// ErrUnmapped is returned when reading an address which isn't in any
+	// PT_LOAD segment.
+	ErrUnmapped = errors.New("address not mapped")
+
+	// ErrOmitted is returned when reading an address which was mapped, but
+	// whose contents weren't written to the core.
+	ErrOmitted = errors.New("address not captured")
+)
+
+// A Note is a raw note from a PT_NOTE segment.
+type Note struct {
+	Name        string
+	Type        elf.NType
+	Description []byte
+}
+
+type File struct {
+	ELF *elf.File
+
+	Notes []*Note
+
+	Process *Process
+	Threads []*Thread
+	Auxv    []AuxvEntry
+	Files   []Mapping
+
+	// Regions, Faults, Metadata and Missing are decoded from the notes
+	// specific to gcore, if present.
+	Regions       []pkgnotes.Region
+	Faults        []proc.Fault
+	FaultsDropped uint64
+	Metadata      *pkgnotes.Metadata
+	Missing       []pkgnotes.Missing
+
+	loads  []*elf.Prog
+	closer io.Closer
+}
+
+func Open(name string) (*File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.closer = f
+
+	return c, nil
+}
+
+func NewFile(r io.ReaderAt) (*File, error) {
+	ef, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if ef.Type != elf.ET_CORE {
+		return nil, fmt.Errorf("not a core file: %s", ef.Type)
+	}
+
+	if ef.Class != elf.ELFCLASS64 || ef.Data != elf.ELFDATA2LSB || ef.Machine != elf.EM_X86_64 {
+		return nil, fmt.Errorf("unsupported core file: %s %s %s", ef.Class, ef.Data, ef.Machine)
+	}
+
+	f := &File{ELF: ef}
+
+	for _, prog := range ef.Progs {
+		switch prog.Type {
+		case elf.PT_LOAD:
+			f.loads = append(f.loads, prog)
+
+		case elf.PT_NOTE:
+			notes, err := readNotes(prog)
+			if err != nil {
+				return nil, err
+			}
+			f.Notes = append(f.Notes, notes...)
+		}
+	}
+
+	for _, n := range f.Notes {
+		err = f.decode(n)
+		if err != nil {
+			return nil, fmt.Errorf("%s note %d: %v", n.Name, n.Type, err)
+		}
+	}
+
+	return f, nil
+}
+
+func (f *File) Close() error {
+	if f.closer != nil {
+		return f.closer.Close()
+	}
+	return nil
+}
+
+func readNotes(prog *elf.Prog) (notes []*Note, err error) {
+	b := make([]byte, prog.Filesz)
+	_, err = io.ReadFull(prog.Open(), b)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(b) > 0 {
+		var h struct {
+			Namesz uint32
+			Descsz uint32
+			Type   uint32
+		}
+
+		if len(b) < binary.Size(h) {
+			return nil, errors.New("truncated note header")
+		}
+		err = binary.Read(bytes.NewReader(b), binary.LittleEndian, &h)
+		if err != nil {
+			return nil, err
+		}
+		b = b[binary.Size(h):]
+
+		namesz, descsz := int(h.Namesz+3)&^3, int(h.Descsz+3)&^3
+		if namesz+descsz > len(b) || int(h.Namesz) > namesz || int(h.Descsz) > descsz {
+			return nil, errors.New("truncated note")
+		}
+
+		notes = append(notes, &Note{
+			Name:        string(bytes.TrimRight(b[:h.Namesz], "\x00")),
+			Type:        elf.NType(h.Type),
+			Description: b[namesz : namesz+int(h.Descsz)],
+		})
+		b = b[namesz+descsz:]
+	}
+
+	return notes, nil
+}
+
+func (f *File) decode(n *Note) (err error) {
+	switch n.Name {
+	case "CORE", "LINUX":
+		var thread *Thread
+		if len(f.Threads) > 0 {
+			thread = f.Threads[len(f.Threads)-1]
+		}
+
+		switch n.Type {
+		case elf.NT_PRPSINFO:
+			f.Process, err = decodePrpsinfo(n.Description)
+
+		case elf.NT_PRSTATUS:
+			thread, err = decodePrstatus(n.Description)
+			if err == nil {
+				f.Threads = append(f.Threads, thread)
+			}
+
+		case elf.NT_FPREGSET, ntX86Xstate, ntSiginfo:
+			if thread == nil {
+				return errors.New("thread note before NT_PRSTATUS")
+			}
+			err = thread.decode(n)
+
+		case ntAuxv:
+			f.Auxv, err = decodeAuxv(n.Description)
+
+		case ntFile:
+			f.Files, err = decodeFile(n.Description)
+		}
+
+	case pkgnotes.GCORE:
+		switch n.Type {
+		case pkgnotes.NT_GCORE_FAULTS:
+			f.Faults, f.FaultsDropped, err = pkgnotes.DecodeFaults(n.Description)
+
+		case pkgnotes.NT_GCORE_REGIONS:
+			f.Regions, err = pkgnotes.DecodeRegions(n.Description)
+
+		case pkgnotes.NT_GCORE_METADATA:
+			f.Metadata, err = pkgnotes.DecodeMetadata(n.Description)
+
+		case pkgnotes.NT_GCORE_MISSING:
+			f.Missing, err = pkgnotes.DecodeMissing(n.Description)
+		}
+	}
+
+	return err
+}
+
+// ReadAt reads the target's memory at virtual address addr, which is taken
+// to be unsigned.  Reads may span adjacent segments.  Parts of segments which
+// weren't written to the core fail with ErrOmitted.
+func (f *File) ReadAt(p []byte, addr int64) (n int, err error) {
+	for n < len(p) {
+		a := uint64(addr) + uint64(n)
+
+		prog := f.load(a)
+		if prog == nil {
+			return n, fmt.Errorf("%#x: %w", a, ErrUnmapped)
+		}
+
+		if a-prog.Vaddr >= prog.Filesz {
+			return n, fmt.Errorf("%#x: %w", a, ErrOmitted)
+		}
+
+		end := len(p)
+		if rem := prog.Filesz - (a - prog.Vaddr); uint64(end-n) > rem {
+			end = n + int(rem)
+		}
+
+		m, err := prog.ReadAt(p[n:end], int64(a-prog.Vaddr))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (f *File) load(addr uint64) *elf.Prog {
+	for _, prog := range f.loads {
+		if addr >= prog.Vaddr && addr-prog.Vaddr < prog.Memsz {
+			return prog
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	pkgelf "github.com/jim-minter/gcore/pkg/elf"
+	pkgnotes "github.com/jim-minter/gcore/pkg/notes"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+func note(t *testing.T, name string, typ uint32, v interface{}) *pkgelf.Note {
+	buf := &bytes.Buffer{}
+
+	err := binary.Write(buf, binary.LittleEndian, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &pkgelf.Note{Name: name, Type: typ, Description: buf.Bytes()}
+}
+
+func TestNewFile(t *testing.T) {
+	prpsinfo := elfPrpsinfo{Sname: 'S', Pid: 42, Ppid: 1, Uid: 1000, Gid: 100}
+	copy(prpsinfo.Fname[:], "sleep")
+	copy(prpsinfo.Psargs[:], "sleep 60")
+
+	prstatus := elfPrstatus{Pid: 42, Ppid: 1, Cursig: 19, Utime: timeval{Sec: 1, Usec: 500}}
+	prstatus.Regs.Rip = 0x401000
+
+	siginfo := Siginfo{Signo: 11, Code: 1}
+	binary.LittleEndian.PutUint64(siginfo.Fields[:], 0xdead)
+
+	regions, err := pkgnotes.Regions([]pkgnotes.Region{{Start: 0x10000, End: 0x12000, Filesz: 0x2000, Reason: proc.ReasonFilter}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := &bytes.Buffer{}
+	for _, v := range []interface{}{
+		pkgelf.File{Count: 1, PageSize: 0x1000},
+		pkgelf.FileElement{Start: 0x400000, End: 0x401000, FileOfs: 2},
+		[]byte("/usr/bin/sleep\x00"),
+	} {
+		binary.Write(file, binary.LittleEndian, v)
+	}
+
+	notes := &bytes.Buffer{}
+	for _, n := range []*pkgelf.Note{
+		note(t, "CORE", uint32(elf.NT_PRPSINFO), &prpsinfo),
+		note(t, "CORE", uint32(elf.NT_PRSTATUS), &prstatus),
+		note(t, "CORE", uint32(elf.NT_FPREGSET), &Fpregs{Mxcsr: 0x1f80}),
+		note(t, "CORE", ntSiginfo, &siginfo),
+		note(t, "CORE", uint32(elf.NT_PRSTATUS), &elfPrstatus{Pid: 43}),
+		note(t, "CORE", ntAuxv, []uint64{6, 0x1000, 0, 0}),
+		{Name: "CORE", Type: ntFile, Description: file.Bytes()},
+		regions,
+	} {
+		err = n.Write(notes)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mem := bytes.Repeat([]byte{1}, 0x1000)
+
+	buf := &bytes.Buffer{}
+	err = pkgelf.Write(buf, &elf.File{
+		FileHeader: elf.FileHeader{Type: elf.ET_CORE},
+		Progs: []*elf.Prog{
+			{ProgHeader: elf.ProgHeader{Type: elf.PT_NOTE, Filesz: uint64(notes.Len())}, ReaderAt: bytes.NewReader(notes.Bytes())},
+			{ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Vaddr: 0x10000, Memsz: 0x1000, Filesz: 0x1000}, ReaderAt: bytes.NewReader(mem)},
+			{ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Vaddr: 0x11000, Memsz: 0x2000, Filesz: 0x1000}, ReaderAt: bytes.NewReader(mem)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, diff := range deep.Equal(f.Process, &Process{State: 'S', Uid: 1000, Gid: 100, Pid: 42, Ppid: 1, Name: "sleep", Args: "sleep 60"}) {
+		t.Error(diff)
+	}
+
+	if len(f.Threads) != 2 {
+		t.Fatalf("got %d threads", len(f.Threads))
+	}
+
+	th := f.Threads[0]
+	if th.Tid != 42 || th.Cursig != 19 || th.Regs.Rip != 0x401000 || th.Utime != time.Second+500*time.Microsecond {
+		t.Errorf("got thread %+v", th)
+	}
+	if th.Fpregs == nil || th.Fpregs.Mxcsr != 0x1f80 {
+		t.Errorf("got fpregs %+v", th.Fpregs)
+	}
+	if th.Siginfo == nil || th.Siginfo.Signo != 11 || th.Siginfo.Addr() != 0xdead {
+		t.Errorf("got siginfo %+v", th.Siginfo)
+	}
+	if f.Threads[1].Tid != 43 || f.Threads[1].Fpregs != nil {
+		t.Errorf("got thread %+v", f.Threads[1])
+	}
+
+	for _, diff := range deep.Equal(f.Auxv, []AuxvEntry{{Type: 6, Val: 0x1000}}) {
+		t.Error(diff)
+	}
+
+	for _, diff := range deep.Equal(f.Files, []Mapping{{Start: 0x400000, End: 0x401000, Offset: 0x2000, Path: "/usr/bin/sleep"}}) {
+		t.Error(diff)
+	}
+
+	if len(f.Regions) != 1 || f.Regions[0].Reason != proc.ReasonFilter {
+		t.Errorf("got regions %+v", f.Regions)
+	}
+
+	b := make([]byte, 0x20)
+	n, err := f.ReadAt(b, 0x10ff0)
+	if err != nil || n != len(b) || !bytes.Equal(b, bytes.Repeat([]byte{1}, len(b))) {
+		t.Errorf("got %d, %v", n, err)
+	}
+
+	n, err = f.ReadAt(b, 0x11ff0)
+	if !errors.Is(err, ErrOmitted) || n != 0x10 {
+		t.Errorf("got %d, %v, want %v", n, err, ErrOmitted)
+	}
+
+	_, err = f.ReadAt(b, 0x20000)
+	if !errors.Is(err, ErrUnmapped) {
+		t.Errorf("got %v, want %v", err, ErrUnmapped)
+	}
+}
@@ -0,0 +1,357 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	pkgelf "github.com/jim-minter/gcore/pkg/elf"
+)
+
+// Note types which debug/elf doesn't define.
+const (
+	ntAuxv      = 6
+	ntX86Xstate = 0x202
+	ntSiginfo   = 0x53494749
+	ntFile      = 0x46494c45
+)
+
+type timeval struct {
+	Sec  int64
+	Usec int64
+}
+
+func (tv timeval) duration() time.Duration {
+	return time.Duration(tv.Sec)*time.Second + time.Duration(tv.Usec)*time.Microsecond
+}
+
+// elfPrstatus is struct elf_prstatus on x86_64.
+type elfPrstatus struct {
+	Signo   int32
+	Code    int32
+	Errno   int32
+	Cursig  int16
+	_       [2]byte
+	Sigpend uint64
+	Sighold uint64
+	Pid     int32
+	Ppid    int32
+	Pgrp    int32
+	Sid     int32
+	Utime   timeval
+	Stime   timeval
+	Cutime  timeval
+	Cstime  timeval
+	Regs    Regs
+	Fpvalid int32
+	_       [4]byte
+}
+
+// elfPrpsinfo is struct elf_prpsinfo on x86_64.
+type elfPrpsinfo struct {
+	State  int8
+	Sname  byte
+	Zomb   int8
+	Nice   int8
+	_      [4]byte
+	Flag   uint64
+	Uid    uint32
+	Gid    uint32
+	Pid    int32
+	Ppid   int32
+	Pgrp   int32
+	Sid    int32
+	Fname  [16]byte
+	Psargs [80]byte
+}
+
+// Regs is struct user_regs_struct on x86_64.
+type Regs struct {
+	R15     uint64
+	R14     uint64
+	R13     uint64
+	R12     uint64
+	Rbp     uint64
+	Rbx     uint64
+	R11     uint64
+	R10     uint64
+	R9      uint64
+	R8      uint64
+	Rax     uint64
+	Rcx     uint64
+	Rdx     uint64
+	Rsi     uint64
+	Rdi     uint64
+	OrigRax uint64
+	Rip     uint64
+	Cs      uint64
+	Eflags  uint64
+	Rsp     uint64
+	Ss      uint64
+	FsBase  uint64
+	GsBase  uint64
+	Ds      uint64
+	Es      uint64
+	Fs      uint64
+	Gs      uint64
+}
+
+// Fpregs is struct user_fpregs_struct on x86_64.
+type Fpregs struct {
+	Cwd      uint16
+	Swd      uint16
+	Ftw      uint16
+	Fop      uint16
+	Rip      uint64
+	Rdp      uint64
+	Mxcsr    uint32
+	MxcrMask uint32
+	StSpace  [32]uint32
+	XmmSpace [64]uint32
+	_        [24]uint32
+}
+
+// Siginfo is siginfo_t.  Fields holds the union whose layout depends on Signo
+// and Code.
+type Siginfo struct {
+	Signo  int32
+	Errno  int32
+	Code   int32
+	_      int32
+	Fields [112]byte
+}
+
+// Addr returns si_addr, which is valid for SIGSEGV, SIGBUS, SIGILL, SIGFPE and
+// SIGTRAP.
+func (si *Siginfo) Addr() uint64 {
+	return binary.LittleEndian.Uint64(si.Fields[:])
+}
+
+// Pid returns si_pid, which is valid for signals sent by kill(2) and SIGCHLD.
+func (si *Siginfo) Pid() int {
+	return int(int32(binary.LittleEndian.Uint32(si.Fields[:])))
+}
+
+// Uid returns si_uid, which is valid for signals sent by kill(2) and SIGCHLD.
+func (si *Siginfo) Uid() int {
+	return int(binary.LittleEndian.Uint32(si.Fields[4:]))
+}
+
+// Process is decoded from NT_PRPSINFO.
+type Process struct {
+	State  byte
+	Zombie bool
+	Nice   int
+	Flag   uint64
+	Uid    int
+	Gid    int
+	Pid    int
+	Ppid   int
+	Pgrp   int
+	Sid    int
+	Name   string
+	Args   string
+}
+
+// Thread is decoded from NT_PRSTATUS and the notes which follow it.
+type Thread struct {
+	Tid     int
+	Ppid    int
+	Pgrp    int
+	Sid     int
+	Cursig  int
+	Sigpend uint64
+	Sighold uint64
+	Utime   time.Duration
+	Stime   time.Duration
+	Cutime  time.Duration
+	Cstime  time.Duration
+	Regs    Regs
+
+	Fpregs  *Fpregs
+	Xstate  []byte
+	Siginfo *Siginfo
+}
+
+type AuxvEntry struct {
+	Type uint64
+	Val  uint64
+}
+
+var auxvNames = map[uint64]string{
+	3:  "AT_PHDR",
+	4:  "AT_PHENT",
+	5:  "AT_PHNUM",
+	6:  "AT_PAGESZ",
+	7:  "AT_BASE",
+	8:  "AT_FLAGS",
+	9:  "AT_ENTRY",
+	11: "AT_UID",
+	12: "AT_EUID",
+	13: "AT_GID",
+	14: "AT_EGID",
+	15: "AT_PLATFORM",
+	16: "AT_HWCAP",
+	17: "AT_CLKTCK",
+	23: "AT_SECURE",
+	24: "AT_BASE_PLATFORM",
+	25: "AT_RANDOM",
+	26: "AT_HWCAP2",
+	27: "AT_RSEQ_FEATURE_SIZE",
+	28: "AT_RSEQ_ALIGN",
+	29: "AT_HWCAP3",
+	30: "AT_HWCAP4",
+	31: "AT_EXECFN",
+	33: "AT_SYSINFO_EHDR",
+	51: "AT_MINSIGSTKSZ",
+}
+
+func (e AuxvEntry) Name() string {
+	if name, ok := auxvNames[e.Type]; ok {
+		return name
+	}
+	return fmt.Sprintf("AT_%d", e.Type)
+}
+
+// Mapping is an entry of NT_FILE.
+type Mapping struct {
+	Start  uint64
+	End    uint64
+	Offset uint64
+	Path   string
+}
+
+func read(desc []byte, v interface{}) error {
+	if len(desc) < binary.Size(v) {
+		return errors.New("truncated description")
+	}
+
+	return binary.Read(bytes.NewReader(desc), binary.LittleEndian, v)
+}
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i != -1 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func decodePrpsinfo(desc []byte) (*Process, error) {
+	var p elfPrpsinfo
+	err := read(desc, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Process{
+		State:  p.Sname,
+		Zombie: p.Zomb != 0,
+		Nice:   int(p.Nice),
+		Flag:   p.Flag,
+		Uid:    int(p.Uid),
+		Gid:    int(p.Gid),
+		Pid:    int(p.Pid),
+		Ppid:   int(p.Ppid),
+		Pgrp:   int(p.Pgrp),
+		Sid:    int(p.Sid),
+		Name:   cstring(p.Fname[:]),
+		Args:   cstring(p.Psargs[:]),
+	}, nil
+}
+
+func decodePrstatus(desc []byte) (*Thread, error) {
+	var p elfPrstatus
+	err := read(desc, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Thread{
+		Tid:     int(p.Pid),
+		Ppid:    int(p.Ppid),
+		Pgrp:    int(p.Pgrp),
+		Sid:     int(p.Sid),
+		Cursig:  int(p.Cursig),
+		Sigpend: p.Sigpend,
+		Sighold: p.Sighold,
+		Utime:   p.Utime.duration(),
+		Stime:   p.Stime.duration(),
+		Cutime:  p.Cutime.duration(),
+		Cstime:  p.Cstime.duration(),
+		Regs:    p.Regs,
+	}, nil
+}
+
+func (t *Thread) decode(n *Note) error {
+	switch n.Type {
+	case ntX86Xstate:
+		t.Xstate = n.Description
+		return nil
+
+	case ntSiginfo:
+		t.Siginfo = &Siginfo{}
+		return read(n.Description, t.Siginfo)
+	}
+
+	t.Fpregs = &Fpregs{}
+	return read(n.Description, t.Fpregs)
+}
+
+func decodeAuxv(desc []byte) (auxv []AuxvEntry, err error) {
+	r := bytes.NewReader(desc)
+
+	for r.Len() >= binary.Size(AuxvEntry{}) {
+		var e AuxvEntry
+		err = binary.Read(r, binary.LittleEndian, &e)
+		if err != nil {
+			return nil, err
+		}
+
+		if e.Type == 0 { // AT_NULL
+			break
+		}
+
+		auxv = append(auxv, e)
+	}
+
+	return auxv, nil
+}
+
+func decodeFile(desc []byte) ([]Mapping, error) {
+	r := bytes.NewReader(desc)
+
+	var file pkgelf.File
+	err := binary.Read(r, binary.LittleEndian, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Count > uint64(r.Len()/binary.Size(pkgelf.FileElement{})) {
+		return nil, errors.New("invalid NT_FILE count")
+	}
+
+	elements := make([]pkgelf.FileElement, file.Count)
+	err = binary.Read(r, binary.LittleEndian, elements)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := bytes.Split(desc[len(desc)-r.Len():], []byte{0})
+	if len(paths) < len(elements) {
+		return nil, errors.New("truncated NT_FILE paths")
+	}
+
+	mappings := make([]Mapping, 0, len(elements))
+	for i, e := range elements {
+		mappings = append(mappings, Mapping{
+			Start:  e.Start,
+			End:    e.End,
+			Offset: e.FileOfs * file.PageSize,
+			Path:   string(paths[i]),
+		})
+	}
+
+	return mappings, nil
+}
@@ -0,0 +1,297 @@
+// Package cri finds containers through the CRI socket of a container runtime,
+// e.g. containerd or CRI-O, with just enough gRPC and protobuf to ask it which
+// containers it runs and what their pids are.
+package cri
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+// DefaultEndpoint is containerd's CRI socket.  crictl(1) takes another from
+// $CONTAINER_RUNTIME_ENDPOINT, as does gcore.
+const DefaultEndpoint = "unix:///run/containerd/containerd.sock"
+
+// containerRunning is CONTAINER_RUNNING in the CRI's ContainerState.
+const containerRunning = 1
+
+type Client struct {
+	hc *http.Client
+}
+
+type Container struct {
+	ID      string
+	Name    string
+	Running bool
+}
+
+// NewClient returns a client of the CRI socket at endpoint, e.g.
+// unix:///run/containerd/containerd.sock.  It connects when first used.
+func NewClient(endpoint string) (*Client, error) {
+	path := strings.TrimPrefix(endpoint, "unix://")
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("unsupported CRI endpoint %q", endpoint)
+	}
+
+	return &Client{
+		hc: &http.Client{
+			Transport: &http2.Transport{
+				// gRPC over a unix socket is HTTP/2 without TLS.
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}, nil
+}
+
+func (c *Client) Close() {
+	c.hc.CloseIdleConnections()
+}
+
+// Containers lists the runtime's containers.
+func (c *Client) Containers(ctx context.Context) ([]Container, error) {
+	b, err := c.call(ctx, "ListContainers", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []Container
+	err = fields(b, func(num int, _ uint64, b []byte) error {
+		if num != 1 {
+			return nil
+		}
+
+		var ct Container
+		err := fields(b, func(num int, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				ct.ID = string(b)
+			case 3: // metadata
+				return fields(b, func(num int, _ uint64, b []byte) error {
+					if num == 1 {
+						ct.Name = string(b)
+					}
+					return nil
+				})
+			case 6:
+				ct.Running = v == containerRunning
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		containers = append(containers, ct)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return containers, nil
+}
+
+// Find returns the running containers whose IDs start with s, or whose names
+// are s, as crictl(1) would.
+func (c *Client) Find(ctx context.Context, s string) ([]Container, error) {
+	containers, err := c.Containers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Container
+	for _, ct := range containers {
+		if ct.Running && (strings.HasPrefix(ct.ID, s) || ct.Name == s) {
+			found = append(found, ct)
+		}
+	}
+
+	return found, nil
+}
+
+// Pid returns the pid of the main process of container id, as the runtime
+// sees it, from the verbose information of its status.
+func (c *Client) Pid(ctx context.Context, id string) (int, error) {
+	req := appendBytes(nil, 1, []byte(id))
+	req = appendVarint(req, 2, 1) // verbose
+
+	b, err := c.call(ctx, "ContainerStatus", req)
+	if err != nil {
+		return 0, err
+	}
+
+	var info []byte
+	err = fields(b, func(num int, _ uint64, b []byte) error {
+		if num != 2 {
+			return nil
+		}
+
+		// A map entry, whose key is 1 and value 2.
+		var key string
+		var value []byte
+		err := fields(b, func(num int, _ uint64, b []byte) error {
+			switch num {
+			case 1:
+				key = string(b)
+			case 2:
+				value = b
+			}
+			return nil
+		})
+		if key == "info" {
+			info = value
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var v struct {
+		Pid int `json:"pid"`
+	}
+	if info != nil {
+		err = json.Unmarshal(info, &v)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if v.Pid == 0 {
+		return 0, fmt.Errorf("no pid found for container %s", id)
+	}
+
+	return v.Pid, nil
+}
+
+// call makes a unary gRPC call of method of the CRI's RuntimeService, with
+// request req, and returns the response.
+func (c *Client) call(ctx context.Context, method string, req []byte) ([]byte, error) {
+	body := make([]byte, 5+len(req))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(req)))
+	copy(body[5:], req)
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://cri/runtime.v1.RuntimeService/"+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/grpc")
+	hreq.Header.Set("TE", "trailers")
+
+	resp, err := c.hc.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", method, resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// A failed call may have no body, in which case its status is sent in the
+	// headers rather than the trailers.
+	status, msg := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		if m, err := url.PathUnescape(msg); err == nil {
+			msg = m
+		}
+		return nil, fmt.Errorf("%s: %s (code %s)", method, msg, status)
+	}
+
+	if len(b) < 5 || b[0] != 0 || int(binary.BigEndian.Uint32(b[1:])) != len(b)-5 {
+		return nil, fmt.Errorf("%s: invalid response", method)
+	}
+
+	return b[5:], nil
+}
+
+var errInvalid = errors.New("invalid protobuf message")
+
+// fields calls f with the number and value of each field of protobuf message
+// b: v for varints, and b for length-delimited fields, such as strings and
+// embedded messages.  Other fields are skipped.
+func fields(b []byte, f func(num int, v uint64, b []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errInvalid
+		}
+		b = b[n:]
+
+		var v uint64
+		var data []byte
+
+		switch tag & 7 {
+		case 0:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errInvalid
+			}
+			b = b[n:]
+
+		case 1:
+			if len(b) < 8 {
+				return errInvalid
+			}
+			b = b[8:]
+
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errInvalid
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+
+		case 5:
+			if len(b) < 4 {
+				return errInvalid
+			}
+			b = b[4:]
+
+		default:
+			return errInvalid
+		}
+
+		err := f(int(tag>>3), v, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendVarint(b []byte, num int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytes(b []byte, num int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
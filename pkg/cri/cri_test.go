@@ -0,0 +1,166 @@
+package cri
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"golang.org/x/net/http2"
+)
+
+// fakeRuntime serves the CRI's ListContainers and ContainerStatus calls on a
+// unix socket, and returns its endpoint.
+func fakeRuntime(t *testing.T, containers []Container, pids map[string]int) string {
+	path := filepath.Join(t.TempDir(), "cri.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil || len(b) < 5 || r.Header.Get("Content-Type") != "application/grpc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req := b[5:]
+
+		w.Header().Set("Content-Type", "application/grpc")
+
+		var resp []byte
+		switch r.URL.Path {
+		case "/runtime.v1.RuntimeService/ListContainers":
+			for _, ct := range containers {
+				var m []byte
+				m = appendBytes(m, 1, []byte(ct.ID))
+				m = appendBytes(m, 3, appendBytes(nil, 1, []byte(ct.Name)))
+				if ct.Running {
+					m = appendVarint(m, 6, containerRunning)
+				} else {
+					m = appendVarint(m, 6, 2)
+				}
+				resp = appendBytes(resp, 1, m)
+			}
+
+		case "/runtime.v1.RuntimeService/ContainerStatus":
+			var id string
+			var verbose bool
+			fields(req, func(num int, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					id = string(b)
+				case 2:
+					verbose = v != 0
+				}
+				return nil
+			})
+
+			pid, ok := pids[id]
+			if !ok {
+				w.Header().Set("Grpc-Status", "5")
+				w.Header().Set("Grpc-Message", "container%20"+id+"%20not%20found")
+				return
+			}
+
+			resp = appendBytes(resp, 1, appendBytes(nil, 1, []byte(id)))
+			if verbose {
+				entry := appendBytes(nil, 1, []byte("info"))
+				entry = appendBytes(entry, 2, []byte(`{"sandboxID":"x","pid":`+strconv.Itoa(pid)+`}`))
+				resp = appendBytes(resp, 2, entry)
+			}
+
+		default:
+			w.Header().Set("Grpc-Status", "12")
+			return
+		}
+
+		w.Header().Set("Trailer", "Grpc-Status")
+
+		frame := make([]byte, 5, 5+len(resp))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(resp)))
+		w.Write(append(frame, resp...))
+
+		w.Header().Set("Grpc-Status", "0")
+	})
+
+	go func() {
+		srv := &http2.Server{}
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.ServeConn(c, &http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+
+	return "unix://" + path
+}
+
+func TestClient(t *testing.T) {
+	containers := []Container{
+		{ID: "abc123", Name: "web", Running: true},
+		{ID: "abd456", Name: "db", Running: true},
+		{ID: "abc789", Name: "web"},
+	}
+
+	c, err := NewClient(fakeRuntime(t, containers, map[string]int{"abc123": 42}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	got, err := c.Containers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, diff := range deep.Equal(got, containers) {
+		t.Error(diff)
+	}
+
+	for s, want := range map[string][]Container{
+		"abc":  containers[:1],
+		"ab":   containers[:2],
+		"web":  containers[:1],
+		"abc7": nil,
+	} {
+		got, err := c.Find(ctx, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, diff := range deep.Equal(got, want) {
+			t.Errorf("%s: %s", s, diff)
+		}
+	}
+
+	pid, err := c.Pid(ctx, "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != 42 {
+		t.Errorf("got pid %d", pid)
+	}
+
+	_, err = c.Pid(ctx, "abd456")
+	if err == nil || !strings.Contains(err.Error(), "container abd456 not found (code 5)") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient("tcp://localhost:1234")
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
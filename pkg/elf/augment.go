@@ -0,0 +1,173 @@
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// AddNotes copies the core file read from r, e.g. one piped by the kernel to a
+// core_pattern handler, to w with an extra PT_NOTE segment after its own.  The
+// core is read once, in order, so r needn't be seekable.  notes is called with
+// the core's program headers, before any segment is read.
+func AddNotes(w io.Writer, r io.Reader, notes func([]elf.ProgHeader) ([]*Note, error)) error {
+	var h elf.Header64
+	err := binary.Read(r, binary.LittleEndian, &h)
+	if err != nil {
+		return err
+	}
+
+	if string(h.Ident[:len(elf.ELFMAG)]) != elf.ELFMAG || elf.Class(h.Ident[elf.EI_CLASS]) != elf.ELFCLASS64 ||
+		elf.Data(h.Ident[elf.EI_DATA]) != elf.ELFDATA2LSB || elf.Type(h.Type) != elf.ET_CORE {
+		return errors.New("not a 64-bit little-endian core file")
+	}
+
+	if h.Phnum == pnXnum {
+		return errors.New("cores with extended program header numbering are not supported")
+	}
+
+	if h.Phentsize != uint16(binary.Size(elf.Prog64{})) || h.Phoff < uint64(binary.Size(h)) {
+		return errors.New("invalid program header table")
+	}
+
+	s := &stream{r: r, off: uint64(binary.Size(h))}
+
+	err = s.discard(h.Phoff)
+	if err != nil {
+		return err
+	}
+
+	phs := make([]elf.Prog64, h.Phnum)
+	err = binary.Read(s, binary.LittleEndian, phs)
+	if err != nil {
+		return err
+	}
+
+	f := &elf.File{
+		FileHeader: elf.FileHeader{
+			Type: elf.ET_CORE,
+		},
+	}
+	var headers []elf.ProgHeader
+	var end uint64
+	last := -1
+
+	for i, ph := range phs {
+		prog := &elf.Prog{
+			ProgHeader: elf.ProgHeader{
+				Type:   elf.ProgType(ph.Type),
+				Flags:  elf.ProgFlag(ph.Flags),
+				Off:    ph.Off,
+				Vaddr:  ph.Vaddr,
+				Paddr:  ph.Paddr,
+				Filesz: ph.Filesz,
+				Memsz:  ph.Memsz,
+				Align:  ph.Align,
+			},
+		}
+
+		if prog.Filesz > 0 {
+			if prog.Off < end {
+				return fmt.Errorf("segment %d overlaps or is out of order", i)
+			}
+			end = prog.Off + prog.Filesz
+
+			prog.ReaderAt = &section{stream: s, off: prog.Off, n: prog.Filesz}
+		}
+
+		if prog.Type == elf.PT_NOTE {
+			last = i
+		}
+
+		f.Progs = append(f.Progs, prog)
+		headers = append(headers, prog.ProgHeader)
+	}
+
+	extra, err := notes(headers)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	for _, n := range extra {
+		err = n.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	prog := &elf.Prog{
+		ProgHeader: elf.ProgHeader{
+			Type:   elf.PT_NOTE,
+			Filesz: uint64(buf.Len()),
+		},
+		ReaderAt: bytes.NewReader(buf.Bytes()),
+	}
+	f.Progs = append(f.Progs[:last+1], append([]*elf.Prog{prog}, f.Progs[last+1:]...)...)
+
+	return Write(w, f)
+}
+
+// A stream is a reader which tracks its offset.
+type stream struct {
+	r   io.Reader
+	off uint64
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.off += uint64(n)
+	return n, err
+}
+
+// discard skips forward to offset off.
+func (s *stream) discard(off uint64) error {
+	if off < s.off {
+		return fmt.Errorf("offset %#x has already been read", off)
+	}
+
+	_, err := io.CopyN(io.Discard, s, int64(off-s.off))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// A section is a segment's contents, read from a stream.  It can only be read
+// once, in order, after any earlier sections.
+type section struct {
+	*stream
+	off, n uint64
+	read   uint64
+}
+
+func (s *section) Read(p []byte) (int, error) {
+	if s.read == s.n {
+		return 0, io.EOF
+	}
+
+	if s.read == 0 {
+		err := s.discard(s.off)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > s.n-s.read {
+		p = p[:s.n-s.read]
+	}
+
+	n, err := s.stream.Read(p)
+	s.read += uint64(n)
+	if err == io.EOF && s.read < s.n {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (s *section) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errors.New("streamed segments can only be read in order")
+}
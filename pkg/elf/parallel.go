@@ -0,0 +1,196 @@
+package elf
+
+import (
+	"debug/elf"
+	"io"
+)
+
+// readAheadChunk is the size of the pieces in which WriteParallel reads
+// segments.
+const readAheadChunk = 4 << 20
+
+// A chunk is a piece of a segment's contents, read by a worker.
+type chunk struct {
+	prog *elf.Prog
+	off  int64
+	len  int64
+
+	// barrier is set for a segment which the writer reads itself.
+	barrier bool
+
+	buf  []byte
+	n    int
+	err  error
+	done chan struct{}
+}
+
+// WriteParallel is Write, but with up to workers goroutines reading ahead the
+// contents of PT_LOAD segments, which must allow concurrent reads, while the
+// writer writes them in order.  The output is identical.  Other segments are
+// read by the writer when it reaches them, once everything before them has
+// been read, as a lazily generated note may depend on that.
+func WriteParallel(w io.Writer, f *elf.File, workers int) error {
+	if workers <= 1 {
+		return Write(w, f)
+	}
+
+	ww, err := writeHeaders(w, f)
+	if err != nil {
+		return err
+	}
+
+	// order holds the chunks in output order, and bounds how far the workers
+	// get ahead of the writer.
+	order := make(chan *chunk, 2*workers)
+	work := make(chan *chunk)
+	ack := make(chan struct{}, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	bufs := make(chan []byte, cap(order)+workers)
+
+	go func() {
+		defer close(order)
+		defer close(work)
+
+		for _, prog := range f.Progs {
+			if prog.Filesz == 0 {
+				continue
+			}
+
+			if prog.Type != elf.PT_LOAD {
+				select {
+				case order <- &chunk{prog: prog, barrier: true}:
+				case <-quit:
+					return
+				}
+
+				select {
+				case <-ack:
+				case <-quit:
+					return
+				}
+
+				continue
+			}
+
+			extents := []Extent{{Len: int64(prog.Filesz)}}
+			if sr, ok := prog.ReaderAt.(SparseReaderAt); ok {
+				var err error
+				extents, err = sr.Extents()
+				if err != nil {
+					c := &chunk{err: err, done: make(chan struct{})}
+					close(c.done)
+
+					select {
+					case order <- c:
+					case <-quit:
+					}
+					return
+				}
+			}
+
+			for _, e := range extents {
+				for off := e.Off; off < e.Off+e.Len; off += readAheadChunk {
+					c := &chunk{
+						prog: prog,
+						off:  off,
+						len:  min64(readAheadChunk, e.Off+e.Len-off),
+						done: make(chan struct{}),
+					}
+
+					select {
+					case order <- c:
+					case <-quit:
+						return
+					}
+
+					select {
+					case work <- c:
+					case <-quit:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for c := range work {
+				select {
+				case c.buf = <-bufs:
+				default:
+					c.buf = make([]byte, readAheadChunk)
+				}
+
+				c.n, c.err = c.prog.ReaderAt.ReadAt(c.buf[:c.len], c.off)
+				if c.err == io.EOF {
+					c.err = nil
+				}
+
+				close(c.done)
+			}
+		}()
+	}
+
+	var last *elf.Prog
+
+	for c := range order {
+		if c.barrier {
+			err = ww.skip(c.prog.Off - ww.off)
+			if err != nil {
+				return err
+			}
+
+			err = ww.copyProg(c.prog)
+			if err != nil {
+				return err
+			}
+
+			last = c.prog
+			ack <- struct{}{}
+			continue
+		}
+
+		<-c.done
+		if c.err != nil {
+			return c.err
+		}
+
+		err = ww.skip(c.prog.Off + uint64(c.off) - ww.off)
+		if err != nil {
+			return err
+		}
+
+		if ww.seekable {
+			err = ww.writeSparse(c.buf[:c.n])
+		} else {
+			_, err = ww.Write(c.buf[:c.n])
+		}
+		if err != nil {
+			return err
+		}
+
+		bufs <- c.buf
+		last = c.prog
+	}
+
+	// A sparse segment may end in a hole.
+	if last != nil && last.Type == elf.PT_LOAD {
+		err = ww.skip(last.Off + last.Filesz - ww.off)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ww.close()
+}
+
+func min64(i, j int64) int64 {
+	if i < j {
+		return i
+	}
+
+	return j
+}
@@ -7,7 +7,14 @@ import (
 	"io"
 )
 
-const align = 0x1000
+const (
+	align       = 0x1000
+	copyBufSize = 1 << 20
+
+	// pnXnum is PN_XNUM from elf(5): if there are at least this many program
+	// headers, the real count is stored in sh_info of section header 0.
+	pnXnum = 0xffff
+)
 
 type ident struct {
 	Magic      [4]byte
@@ -58,6 +65,16 @@ func calcProgs(f *elf.File, h *elf.Header64) {
 	base := int(h.Ehsize)
 	base += len(f.Progs) * int(h.Phentsize)
 
+	if len(f.Progs) >= pnXnum {
+		h.Phnum = pnXnum
+		h.Shoff = uint64(base)
+		h.Shentsize = uint16(binary.Size(&elf.Section64{}))
+		h.Shnum = 1
+		h.Shstrndx = uint16(elf.SHN_UNDEF)
+
+		base += int(h.Shentsize)
+	}
+
 	for i := range f.Progs {
 		f.Progs[i].Off = uint64(base)
 		base += int(f.Progs[i].Filesz)
@@ -66,39 +83,190 @@ func calcProgs(f *elf.File, h *elf.Header64) {
 	}
 }
 
-func Write(w io.Writer, f *elf.File) error {
-	h, err := newHeader(f)
-	if err != nil {
-		return err
+// An Extent is a range of a segment's contents which holds data.
+type Extent struct {
+	Off int64
+	Len int64
+}
+
+// SparseReaderAt is implemented by segment contents which are mostly zero.
+// Anything outside the returned Extents, which must be sorted, reads as zero
+// and is never read; it is seeked over if the output is seekable.
+type SparseReaderAt interface {
+	io.ReaderAt
+	Extents() ([]Extent, error)
+}
+
+type writer struct {
+	w        io.Writer
+	off      uint64
+	seekable bool
+	skipped  bool
+}
+
+func newWriter(w io.Writer) *writer {
+	ww := &writer{w: w}
+
+	if s, ok := w.(io.Seeker); ok {
+		_, err := s.Seek(0, io.SeekCurrent)
+		ww.seekable = err == nil
+	}
+
+	return ww
+}
+
+// ReadFrom copies r to the output.  If the output is seekable, all-zero pages
+// are seeked over rather than written, leaving holes in the output file.
+func (w *writer) ReadFrom(r io.Reader) (n int64, err error) {
+	if !w.seekable {
+		return io.Copy(struct{ io.Writer }{w}, r)
+	}
+
+	buf := make([]byte, copyBufSize)
+
+	for {
+		m, err := io.ReadFull(r, buf)
+		if m > 0 {
+			werr := w.writeSparse(buf[:m])
+			if werr != nil {
+				return n, werr
+			}
+			n += int64(m)
+		}
+
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return n, nil
+		default:
+			return n, err
+		}
+	}
+}
+
+func (w *writer) writeSparse(b []byte) error {
+	for len(b) > 0 {
+		n := min(align, uint64(len(b)))
+		zero := isZero(b[:n])
+
+		for n < uint64(len(b)) {
+			m := min(n+align, uint64(len(b)))
+			if isZero(b[n:m]) != zero {
+				break
+			}
+			n = m
+		}
+
+		var err error
+		if zero {
+			err = w.skip(n)
+		} else {
+			_, err = w.Write(b[:n])
+		}
+		if err != nil {
+			return err
+		}
+
+		b = b[n:]
+	}
+
+	return nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.off += uint64(n)
+	w.skipped = false
+	return n, err
+}
+
+func (w *writer) skip(n uint64) error {
+	if n == 0 {
+		return nil
+	}
+
+	if w.seekable {
+		_, err := w.w.(io.Seeker).Seek(int64(n), io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		w.off += n
+		w.skipped = true
+		return nil
+	}
+
+	for n > 0 {
+		m, err := w.Write(make([]byte, min(n, align)))
+		if err != nil {
+			return err
+		}
+
+		n -= uint64(m)
+	}
+
+	return nil
+}
+
+// close ensures that a trailing skipped region still extends the output.
+func (w *writer) close() error {
+	if !w.skipped {
+		return nil
 	}
 
-	err = binary.Write(w, binary.LittleEndian, h)
+	_, err := w.w.(io.Seeker).Seek(-1, io.SeekCurrent)
 	if err != nil {
 		return err
 	}
 
-	off := uint64(binary.Size(h))
+	_, err = w.Write([]byte{0})
+	return err
+}
 
-	for i, prog := range f.Progs {
-		ph := &elf.Prog64{
-			Type:   uint32(prog.Type),
-			Flags:  uint32(prog.Flags),
-			Off:    prog.Off,
-			Vaddr:  prog.Vaddr,
-			Filesz: prog.Filesz,
-			Memsz:  prog.Memsz,
-		}
+func (w *writer) copyProg(prog *elf.Prog) error {
+	sr, ok := prog.ReaderAt.(SparseReaderAt)
+	if !ok {
+		_, err := io.Copy(w, prog.ReaderAt.(io.Reader))
+		return err
+	}
 
-		if i > 0 {
-			ph.Align = align
+	extents, err := sr.Extents()
+	if err != nil {
+		return err
+	}
+
+	var pos int64
+	for _, e := range extents {
+		err = w.skip(uint64(e.Off - pos))
+		if err != nil {
+			return err
 		}
 
-		err = binary.Write(w, binary.LittleEndian, ph)
+		_, err = io.Copy(w, io.NewSectionReader(sr, e.Off, e.Len))
 		if err != nil {
 			return err
 		}
 
-		off += uint64(binary.Size(ph))
+		pos = e.Off + e.Len
+	}
+
+	return w.skip(prog.Filesz - uint64(pos))
+}
+
+func Write(w io.Writer, f *elf.File) error {
+	ww, err := writeHeaders(w, f)
+	if err != nil {
+		return err
 	}
 
 	for _, prog := range f.Progs {
@@ -106,24 +274,66 @@ func Write(w io.Writer, f *elf.File) error {
 			continue
 		}
 
-		for prog.Off > off {
-			n, err := w.Write(make([]byte, min(prog.Off-off, align)))
-			if err != nil {
-				return err
-			}
-
-			off += uint64(n)
+		err = ww.skip(prog.Off - ww.off)
+		if err != nil {
+			return err
 		}
 
-		n, err := io.Copy(w, prog.ReaderAt.(io.Reader))
+		err = ww.copyProg(prog)
 		if err != nil {
 			return err
 		}
+	}
 
-		off += uint64(n)
+	return ww.close()
+}
+
+func writeHeaders(w io.Writer, f *elf.File) (*writer, error) {
+	h, err := newHeader(f)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	ww := newWriter(w)
+
+	err = binary.Write(ww, binary.LittleEndian, h)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, prog := range f.Progs {
+		ph := &elf.Prog64{
+			Type:   uint32(prog.Type),
+			Flags:  uint32(prog.Flags),
+			Off:    prog.Off,
+			Vaddr:  prog.Vaddr,
+			Filesz: prog.Filesz,
+			Memsz:  prog.Memsz,
+		}
+
+		if prog.Type == elf.PT_LOAD {
+			ph.Align = align
+		}
+
+		err = binary.Write(ww, binary.LittleEndian, ph)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if h.Phnum == pnXnum {
+		err = binary.Write(ww, binary.LittleEndian, &elf.Section64{
+			Type: uint32(elf.SHT_NULL),
+			Size: uint64(h.Shnum),
+			Link: uint32(h.Shstrndx),
+			Info: uint32(len(f.Progs)),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ww, nil
 }
 
 func min(i, j uint64) uint64 {
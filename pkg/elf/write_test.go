@@ -0,0 +1,169 @@
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"testing"
+)
+
+func TestWriteExtendedNumbering(t *testing.T) {
+	const n = 70000
+
+	data := []byte("hello, world")
+
+	f := &elf.File{
+		FileHeader: elf.FileHeader{
+			Type: elf.ET_CORE,
+		},
+	}
+	for i := 0; i < n; i++ {
+		prog := &elf.Prog{
+			ProgHeader: elf.ProgHeader{
+				Type:  elf.PT_LOAD,
+				Flags: elf.PF_R,
+				Vaddr: uint64(i) * align,
+				Memsz: align,
+			},
+		}
+
+		if i%10000 == 0 {
+			prog.Filesz = uint64(len(data))
+			prog.ReaderAt = bytes.NewReader(data)
+		}
+
+		f.Progs = append(f.Progs, prog)
+	}
+
+	buf := &bytes.Buffer{}
+	err := Write(buf, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Progs) != n {
+		t.Fatalf("got %d progs, want %d", len(got.Progs), n)
+	}
+
+	for i, prog := range got.Progs {
+		if prog.Vaddr != uint64(i)*align || prog.Memsz != align {
+			t.Fatalf("prog %d: unexpected header %#v", i, prog.ProgHeader)
+		}
+
+		if i%10000 == 0 {
+			b := make([]byte, prog.Filesz)
+			_, err = prog.ReadAt(b, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b, data) {
+				t.Errorf("prog %d: got %q, want %q", i, b, data)
+			}
+		}
+	}
+}
+
+// sparseReader is a SparseReaderAt over a bytes.Reader.
+type sparseReader struct {
+	*bytes.Reader
+	extents []Extent
+}
+
+func (r *sparseReader) Extents() ([]Extent, error) {
+	return r.extents, nil
+}
+
+func TestWriteParallel(t *testing.T) {
+	data := make([]byte, 2*readAheadChunk+3*align)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	for i := align; i < 2*align; i++ {
+		data[i] = 0
+	}
+
+	sparse := make([]byte, readAheadChunk+4*align)
+	copy(sparse[align:], data[:readAheadChunk+align])
+
+	newFile := func() *elf.File {
+		f := &elf.File{
+			FileHeader: elf.FileHeader{
+				Type: elf.ET_CORE,
+			},
+		}
+
+		note := []byte("a note")
+		f.Progs = append(f.Progs,
+			&elf.Prog{
+				ProgHeader: elf.ProgHeader{Type: elf.PT_NOTE, Filesz: uint64(len(note))},
+				ReaderAt:   bytes.NewReader(note),
+			},
+			&elf.Prog{
+				ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Vaddr: 0x10000, Filesz: uint64(len(data)), Memsz: uint64(len(data))},
+				ReaderAt:   bytes.NewReader(data),
+			},
+			&elf.Prog{
+				ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Vaddr: 0x10000000},
+			},
+			&elf.Prog{
+				ProgHeader: elf.ProgHeader{Type: elf.PT_NOTE, Filesz: uint64(len(note))},
+				ReaderAt:   bytes.NewReader(note),
+			},
+			// The last segment ends in a hole.
+			&elf.Prog{
+				ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Vaddr: 0x20000000, Filesz: uint64(len(sparse)), Memsz: uint64(len(sparse))},
+				ReaderAt: &sparseReader{
+					Reader:  bytes.NewReader(sparse),
+					extents: []Extent{{Off: align, Len: readAheadChunk}, {Off: readAheadChunk + align, Len: align}},
+				},
+			},
+		)
+
+		return f
+	}
+
+	want := &bytes.Buffer{}
+	err := Write(want, newFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{2, 5} {
+		got := &bytes.Buffer{}
+		err = WriteParallel(got, newFile(), workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("%d workers: output differs when not seekable", workers)
+		}
+
+		f, err := os.CreateTemp("", "gcore-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		err = WriteParallel(f, newFile(), workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(b, want.Bytes()) {
+			t.Errorf("%d workers: output differs when seekable", workers)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package gcore
+
+import (
+	"debug/elf"
+	"io"
+
+	pkgelf "github.com/jim-minter/gcore/pkg/elf"
+	pkgnotes "github.com/jim-minter/gcore/pkg/notes"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+// Enrich copies a core of process pid written by someone else, typically the
+// kernel piping it to a core_pattern handler, from r to w, adding gcore's
+// regions note and a note holding m.  The process's mappings are read before
+// r, as the kernel only keeps the process around while it is still writing
+// the core.
+func Enrich(r io.Reader, w io.Writer, m *pkgnotes.Metadata) error {
+	smaps, err := proc.ReadSmaps(m.Pid)
+	if err != nil {
+		return err
+	}
+
+	filter, err := proc.ReadCoredumpFilter(m.Pid)
+	if err != nil {
+		return err
+	}
+
+	var isElf func(*proc.Smap) bool
+	if mem, err := proc.Mem(m.Pid); err == nil {
+		defer mem.Close()
+		isElf = isELF(mem)
+	} else {
+		isElf = func(*proc.Smap) bool { return false }
+	}
+
+	// The reasons are gcore's reading of the coredump filter; the sizes are
+	// whatever the core actually holds.
+	regions := make([]pkgnotes.Region, 0, len(smaps))
+	for _, smap := range smaps {
+		reason := proc.ReasonUnreadable
+		if smap.Perms&proc.PermR != 0 {
+			_, reason = filter.Classify(smap, isElf)
+		}
+
+		regions = append(regions, pkgnotes.Region{
+			Start:     smap.Start,
+			End:       smap.End,
+			Perms:     smap.Perms,
+			Reason:    reason,
+			Rss:       smap.Bytes("rss"),
+			Swap:      smap.Bytes("swap"),
+			Anonymous: smap.Bytes("anonymous"),
+			VmFlags:   smap.Data["vmflags"],
+			Pathname:  smap.Pathname,
+		})
+	}
+
+	mn, err := pkgnotes.MetadataNote(m)
+	if err != nil {
+		return err
+	}
+
+	return pkgelf.AddNotes(w, r, func(progs []elf.ProgHeader) ([]*pkgelf.Note, error) {
+		filesz := map[uint64]uint64{}
+		for _, prog := range progs {
+			if prog.Type == elf.PT_LOAD {
+				filesz[prog.Vaddr] = prog.Filesz
+			}
+		}
+
+		for i := range regions {
+			regions[i].Filesz = filesz[regions[i].Start]
+		}
+
+		rn, err := pkgnotes.Regions(regions)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*pkgelf.Note{rn, mn}, nil
+	})
+}
@@ -0,0 +1,104 @@
+package gcore
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/jim-minter/gcore/pkg/core"
+	pkgnotes "github.com/jim-minter/gcore/pkg/notes"
+)
+
+func TestEnrich(t *testing.T) {
+	pid := startSleep(t)
+
+	// Stand in for a core written by the kernel, which has no gcore notes.
+	in := &bytes.Buffer{}
+	_, err := Dump(context.Background(), pid, in, Options{Notes: AllNotes &^ (NoteFaults | NoteRegions)})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orig, err := core.NewFile(bytes.NewReader(in.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := pkgnotes.ReadMetadata(pid, pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	err = Enrich(struct{ io.Reader }{bytes.NewReader(in.Bytes())}, out, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := core.NewFile(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Metadata == nil || f.Metadata.Pid != pid || f.Metadata.Tid != pid || len(f.Metadata.Namespaces) == 0 {
+		t.Errorf("got metadata %+v", f.Metadata)
+	}
+
+	var found bool
+	for _, mf := range f.Metadata.Files {
+		if mf.BuildID != "" && mf.Size > 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no mapped file with a build ID in %+v", f.Metadata.Files)
+	}
+
+	if len(f.Threads) != len(orig.Threads) || f.Process == nil || f.Process.Pid != pid {
+		t.Errorf("lost the original notes")
+	}
+
+	if len(f.ELF.Progs) != len(orig.ELF.Progs)+1 {
+		t.Errorf("got %d progs, want %d", len(f.ELF.Progs), len(orig.ELF.Progs)+1)
+	}
+
+	filesz := map[uint64]uint64{}
+	for _, prog := range orig.ELF.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		filesz[prog.Vaddr] = prog.Filesz
+
+		want := make([]byte, prog.Filesz)
+		_, err = orig.ReadAt(want, int64(prog.Vaddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make([]byte, prog.Filesz)
+		_, err = f.ReadAt(got, int64(prog.Vaddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got, want) {
+			t.Errorf("segment %#x differs", prog.Vaddr)
+		}
+	}
+
+	if len(f.Regions) != len(filesz) {
+		t.Errorf("got %d regions, want %d", len(f.Regions), len(filesz))
+	}
+	for _, r := range f.Regions {
+		if r.Filesz != filesz[r.Start] {
+			t.Errorf("region %#x: got filesz %#x, want %#x", r.Start, r.Filesz, filesz[r.Start])
+		}
+	}
+}
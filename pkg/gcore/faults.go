@@ -0,0 +1,72 @@
+package gcore
+
+import (
+	"bytes"
+	"debug/elf"
+	"sync"
+
+	pkgnotes "github.com/jim-minter/gcore/pkg/notes"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+// faultsCapacity is the number of faults for which space is reserved in the
+// faults note.
+const faultsCapacity = 256
+
+// faultsProg returns a trailing PT_NOTE segment listing the faults recorded by
+// tr.  Its contents are only generated when it is first read, by which time
+// the preceding segments have been written.
+func faultsProg(tr *proc.TolerantReader) *elf.Prog {
+	return &elf.Prog{
+		ProgHeader: elf.ProgHeader{
+			Type:   elf.PT_NOTE,
+			Filesz: uint64(pkgnotes.FaultsSize(faultsCapacity)),
+		},
+		ReaderAt: &lazyReader{
+			f: func() ([]byte, error) {
+				n, err := pkgnotes.Faults(tr.Faults(), faultsCapacity)
+				if err != nil {
+					return nil, err
+				}
+
+				buf := &bytes.Buffer{}
+				err = n.Write(buf)
+				return buf.Bytes(), err
+			},
+		},
+	}
+}
+
+// lazyReader reads the bytes returned by f, which is called on first use.
+type lazyReader struct {
+	f    func() ([]byte, error)
+	once sync.Once
+	r    *bytes.Reader
+	err  error
+}
+
+func (r *lazyReader) init() error {
+	r.once.Do(func() {
+		var b []byte
+		b, r.err = r.f()
+		r.r = bytes.NewReader(b)
+	})
+
+	return r.err
+}
+
+func (r *lazyReader) Read(p []byte) (int, error) {
+	if err := r.init(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
+
+func (r *lazyReader) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.init(); err != nil {
+		return 0, err
+	}
+
+	return r.r.ReadAt(p, off)
+}
@@ -0,0 +1,48 @@
+package gcore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jim-minter/gcore/pkg/proc"
+	"github.com/jim-minter/gcore/pkg/ptrace"
+)
+
+func dumpFrozen(ctx context.Context, pid int, w io.Writer, opts Options) (result *Result, err error) {
+	start := time.Now()
+
+	thaw, err := proc.Freeze(ctx, opts.Freeze)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if terr := thaw(); err == nil {
+			err = terr
+		}
+		if result != nil {
+			result.Pause = time.Since(start)
+		}
+	}()
+
+	t := &target{pid: pid}
+
+	// A frozen thread still stops for ptrace, so seizing it only fails if
+	// ptrace is refused altogether.
+	t.s, t.err = ptrace.Seize(pid)
+	if t.err != nil {
+		t.err = fmt.Errorf("seize: %w", t.err)
+	} else {
+		defer t.s.Detach()
+	}
+
+	d, err := prepare(t, pid, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+
+	return d.write(ctx, w)
+}
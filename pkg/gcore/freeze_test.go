@@ -0,0 +1,132 @@
+package gcore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jim-minter/gcore/pkg/core"
+	"github.com/jim-minter/gcore/pkg/proc"
+	"github.com/jim-minter/gcore/pkg/ptrace"
+)
+
+// startFrozenSleep starts a sleep in a cgroup of its own, and returns its pid
+// and cgroup directory.
+func startFrozenSleep(t *testing.T) (int, string) {
+	// The root cgroup can't be frozen, but a cgroup under it can.
+	parent, err := proc.CgroupDir(os.Getpid(), "/")
+	if err != nil {
+		parent = proc.CgroupMount("/")
+	}
+
+	dir := filepath.Join(parent, fmt.Sprintf("gcore-test.%d", os.Getpid()))
+
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	t.Cleanup(func() {
+		// The sleep may take a moment to leave once killed.
+		for i := 0; i < 100; i++ {
+			if os.Remove(dir) == nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Errorf("couldn't remove %s", dir)
+	})
+
+	pid := startSleep(t)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	return pid, dir
+}
+
+func TestDumpFrozen(t *testing.T) {
+	pid, dir := startFrozenSleep(t)
+
+	for _, seized := range []bool{false, true} {
+		t.Run(fmt.Sprintf("seized=%v", seized), func(t *testing.T) {
+			// Stand in for another tracer.
+			if seized {
+				s, err := ptrace.Seize(pid)
+				if errors.Is(err, syscall.EPERM) {
+					t.Skip(err)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer s.Detach()
+			}
+
+			buf := &bytes.Buffer{}
+
+			_, err := Dump(context.Background(), pid, buf, Options{Freeze: dir})
+			if errors.Is(err, syscall.EPERM) {
+				t.Skip(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			frozen, err := isFrozen(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frozen {
+				t.Error("cgroup still frozen")
+			}
+
+			f, err := core.NewFile(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(f.Threads) != 1 || f.Threads[0].Tid != pid || f.Threads[0].Regs.Rsp == 0 {
+				t.Fatalf("got threads %+v", f.Threads)
+			}
+
+			b := make([]byte, 8)
+			_, err = f.ReadAt(b, int64(f.Threads[0].Regs.Rsp))
+			if err != nil {
+				t.Errorf("reading stack: %v", err)
+			}
+
+			if !seized {
+				if len(f.Missing) != 0 {
+					t.Errorf("got missing %+v", f.Missing)
+				}
+				return
+			}
+
+			if len(f.Missing) != 4 || f.Missing[0].Type != "NT_PRSTATUS" || !f.Missing[0].Partial {
+				t.Errorf("got missing %+v", f.Missing)
+			}
+			if f.Threads[0].Fpregs != nil || f.Threads[0].Siginfo != nil {
+				t.Errorf("got thread %+v", f.Threads[0])
+			}
+		})
+	}
+}
+
+func isFrozen(dir string) (bool, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.events"))
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Contains(b, []byte("frozen 1")), nil
+}
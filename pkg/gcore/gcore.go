@@ -2,9 +2,17 @@ package gcore
 
 import (
 	"bytes"
+	"context"
 	"debug/elf"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"runtime"
+	"sort"
+	"time"
+
+	"golang.org/x/sys/unix"
 
 	pkgelf "github.com/jim-minter/gcore/pkg/elf"
 	pkgnotes "github.com/jim-minter/gcore/pkg/notes"
@@ -12,27 +20,118 @@ import (
 	"github.com/jim-minter/gcore/pkg/ptrace"
 )
 
-func notes(pid int, tids []int) (*elf.Prog, error) {
-	buf := &bytes.Buffer{}
+type Notes uint
 
-	n, err := pkgnotes.Prpsinfo(pid)
-	if err != nil {
-		return nil, err
+const (
+	NotePrpsinfo Notes = 1 << iota
+	NotePrstatus
+	NoteFpregset
+	NoteXstate
+	NoteSiginfo
+	NoteAuxv
+	NoteFile
+	NoteFaults
+	NoteRegions
+	NoteMissing
+
+	AllNotes = NotePrpsinfo | NotePrstatus | NoteFpregset | NoteXstate | NoteSiginfo | NoteAuxv | NoteFile | NoteFaults | NoteRegions | NoteMissing
+)
+
+// A target is a stopped process to be dumped: seized by s or, if s is nil,
+// frozen by other means because it couldn't be seized, for reason err.
+type target struct {
+	pid int
+	s   *ptrace.Session
+	err error
+}
+
+func (t *target) tids() ([]int, error) {
+	if t.s != nil {
+		return t.s.Tids(), nil
 	}
 
-	err = n.Write(buf)
+	tids, err := proc.Tasks(t.pid)
 	if err != nil {
 		return nil, err
 	}
+	sort.Ints(tids)
+
+	return tids, nil
+}
+
+// notes builds the PT_NOTE segment.  If t isn't seized, the notes which need
+// ptrace are left out, apart from a partial NT_PRSTATUS, and listed in an
+// NT_GCORE_MISSING note instead.
+func notes(t *target, tids []int, regions []pkgnotes.Region, sel Notes) (*elf.Prog, error) {
+	pid := t.pid
+	buf := &bytes.Buffer{}
+
+	var missing []pkgnotes.Missing
+
+	if sel&NotePrpsinfo != 0 {
+		n, err := pkgnotes.Prpsinfo(pid)
+		if err != nil {
+			return nil, err
+		}
+
+		err = n.Write(buf)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	for _, tid := range tids {
-		for _, f := range []func(int, int) (*pkgelf.Note, error){
-			pkgnotes.Prstatus,
-			pkgnotes.Fpregset,
-			pkgnotes.Xstate,
-			pkgnotes.Siginfo,
+		if t.s == nil {
+			for _, f := range []struct {
+				note Notes
+				typ  string
+			}{
+				{NotePrstatus, "NT_PRSTATUS"},
+				{NoteFpregset, "NT_FPREGSET"},
+				{NoteXstate, "NT_X86_XSTATE"},
+				{NoteSiginfo, "NT_SIGINFO"},
+			} {
+				if sel&f.note == 0 {
+					continue
+				}
+
+				m := pkgnotes.Missing{Type: f.typ, Tid: tid, Err: t.err.Error()}
+
+				if f.note == NotePrstatus {
+					n, err := pkgnotes.PartialPrstatus(pid, tid)
+					if err == nil {
+						err = n.Write(buf)
+						if err != nil {
+							return nil, err
+						}
+
+						m.Partial = true
+						m.Err = "only rsp and rip, and any system call, are known: " + m.Err
+					}
+				}
+
+				missing = append(missing, m)
+			}
+
+			continue
+		}
+
+		sig := t.s.Signal(tid)
+
+		for _, f := range []struct {
+			note Notes
+			f    func() (*pkgelf.Note, error)
+		}{
+			{NotePrstatus, func() (*pkgelf.Note, error) { return pkgnotes.Prstatus(pid, tid, sig) }},
+			{NoteFpregset, func() (*pkgelf.Note, error) { return pkgnotes.Fpregset(pid, tid) }},
+			{NoteXstate, func() (*pkgelf.Note, error) { return pkgnotes.Xstate(pid, tid) }},
+			{NoteSiginfo, func() (*pkgelf.Note, error) { return pkgnotes.Siginfo(pid, tid, sig) }},
 		} {
-			n, err = f(pid, tid)
+			if sel&f.note == 0 {
+				continue
+			}
+
+			n, err := f.f()
 			if err != nil {
 				return nil, err
 			}
@@ -44,11 +143,42 @@ func notes(pid int, tids []int) (*elf.Prog, error) {
 		}
 	}
 
-	for _, f := range []func(int) (*pkgelf.Note, error){
-		pkgnotes.ReadAuxv,
-		pkgnotes.File,
+	for _, f := range []struct {
+		note Notes
+		f    func(int) (*pkgelf.Note, error)
+	}{
+		{NoteAuxv, pkgnotes.ReadAuxv},
+		{NoteFile, pkgnotes.File},
 	} {
-		n, err = f(pid)
+		if sel&f.note == 0 {
+			continue
+		}
+
+		n, err := f.f(pid)
+		if err != nil {
+			return nil, err
+		}
+
+		err = n.Write(buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if sel&NoteRegions != 0 {
+		n, err := pkgnotes.Regions(regions)
+		if err != nil {
+			return nil, err
+		}
+
+		err = n.Write(buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if sel&NoteMissing != 0 && len(missing) > 0 {
+		n, err := pkgnotes.MissingNote(missing)
 		if err != nil {
 			return nil, err
 		}
@@ -68,15 +198,27 @@ func notes(pid int, tids []int) (*elf.Prog, error) {
 	}, nil
 }
 
-func progs(pid int) (progs []*elf.Prog, err error) {
-	mem, err := proc.Mem(pid)
-	if err != nil {
-		return nil, err
+func isELF(mem io.ReaderAt) func(*proc.Smap) bool {
+	return func(smap *proc.Smap) bool {
+		b := make([]byte, len(elf.ELFMAG))
+		_, err := mem.ReadAt(b, int64(smap.Start))
+		return err == nil && string(b) == elf.ELFMAG
 	}
+}
 
+func progs(pid int, mem io.ReaderAt, tr *proc.TolerantReader, pagemap *os.File, opts Options) (progs []*elf.Prog, regions []pkgnotes.Region, err error) {
 	smaps, err := proc.ReadSmaps(pid)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	filter := opts.CoredumpFilter
+	if filter == nil {
+		f, err := proc.ReadCoredumpFilter(pid)
+		if err != nil {
+			return nil, nil, err
+		}
+		filter = &f
 	}
 
 	for _, smap := range smaps {
@@ -98,42 +240,453 @@ func progs(pid int) (progs []*elf.Prog, err error) {
 			prog.Flags |= elf.PF_X
 		}
 
-		if !smap.HasVMFlag("dd") &&
-			!smap.HasVMFlag("io") &&
-			smap.Perms&proc.PermR != 0 &&
-			int64(smap.Start) >= 0 /* TODO: hack */ {
-			prog.Filesz = prog.Memsz
-			prog.ReaderAt = io.NewSectionReader(mem, int64(smap.Start), int64(smap.End-smap.Start))
+		// The fork's copy of a MADV_WIPEONFORK mapping reads as zeros, which
+		// the target's doesn't, so it is recorded as faults instead.
+		wiped := opts.Fork && smap.HasVMFlag("wf")
+		if wiped {
+			tr.Exclude(smap.Start, smap.End, errWipedOnFork)
+		}
+
+		reason := proc.ReasonUnreadable
+		if smap.Perms&proc.PermR != 0 {
+			prog.Filesz, reason = filter.Classify(smap, isELF(mem))
+			prog.ReaderAt = io.NewSectionReader(tr, int64(smap.Start), int64(prog.Filesz))
+
+			if pagemap != nil && !wiped {
+				prog.ReaderAt = &residentReader{
+					SectionReader: prog.ReaderAt.(*io.SectionReader),
+					pagemap:       pagemap,
+					start:         smap.Start,
+					skipSwapped:   opts.SkipSwapped,
+				}
+			}
 		}
 
 		progs = append(progs, prog)
+
+		regions = append(regions, pkgnotes.Region{
+			Start:     smap.Start,
+			End:       smap.End,
+			Filesz:    prog.Filesz,
+			Perms:     smap.Perms,
+			Reason:    reason,
+			Rss:       smap.Bytes("rss"),
+			Swap:      smap.Bytes("swap"),
+			Anonymous: smap.Bytes("anonymous"),
+			VmFlags:   smap.Data["vmflags"],
+			Pathname:  smap.Pathname,
+		})
 	}
 
-	return progs, nil
+	return progs, regions, nil
+}
+
+type Options struct {
+	// Notes selects the notes to write.  The zero value means AllNotes.
+	Notes Notes
+
+	// Threads restricts the per-thread notes to the given thread IDs.  All
+	// threads are stopped regardless.  By default, every thread is included.
+	Threads []int
+
+	// CoredumpFilter overrides the target's /proc/<pid>/coredump_filter.
+	CoredumpFilter *proc.CoredumpFilter
+
+	// Resident only captures pages which are present or swapped, according to
+	// /proc/<pid>/pagemap.  Never-touched pages are left as holes.
+	Resident bool
+
+	// SkipSwapped, which implies Resident, also leaves swapped-out pages as
+	// holes rather than forcing them to be swapped in.
+	SkipSwapped bool
+
+	// Fork has Dump make the target fork, and then read its memory from the
+	// copy-on-write child, so that the target is only stopped while its notes
+	// and memory map are read.  Memory the target has marked MADV_DONTFORK is
+	// missing from the child, and memory marked MADV_WIPEONFORK is zeroed in
+	// it, so both are recorded as faults.
+	Fork bool
+
+	// Precopy has Dump copy the target's memory while it runs, and then stop
+	// it only to copy again the pages which have been written to since,
+	// according to their soft-dirty bits.  The copy is staged in a temporary
+	// file, in $TMPDIR, which is as large as the memory captured.
+	Precopy bool
+
+	// Freeze is a cgroup v2 directory containing the target, e.g. its
+	// container's, which Dump freezes through cgroup.freeze rather than
+	// stopping the target with ptrace, and thaws afterwards.  Dump still tries
+	// to seize the target, for its registers; if it can't, e.g. because
+	// another tracer is attached, the notes which need ptrace are listed as
+	// missing in an NT_GCORE_MISSING note.
+	Freeze string
+
+	// Readers is the number of goroutines reading memory ahead of the writer.
+	// The zero value means one per CPU; 1 reads memory as it is written.
+	Readers int
 }
 
-func Run(pid int) error {
-	tids, err := ptrace.Seize(pid)
+type Result struct {
+	// Segments are the program headers written to the core.
+	Segments []elf.ProgHeader
+
+	// Bytes is the number of bytes written to the output.  It excludes holes
+	// which were seeked over.
+	Bytes int64
+
+	// Pause is how long the target was stopped for.
+	Pause time.Duration
+
+	// Recopied is the number of bytes which Precopy copied again while the
+	// target was stopped.
+	Recopied int64
+
+	// Faults are the memory ranges which couldn't be read and were written as
+	// zeros instead.
+	Faults []proc.Fault
+
+	// Tid and Signal are set by Catch to the thread which received the fatal
+	// signal, and the signal.
+	Tid    int
+	Signal unix.Signal
+}
+
+// Dump writes a core file of process pid to w.  The target is stopped while
+// its state is read and is always resumed before Dump returns, including when
+// ctx is cancelled.
+func Dump(ctx context.Context, pid int, w io.Writer, opts Options) (result *Result, err error) {
+	switch {
+	case opts.Fork && opts.Precopy, opts.Fork && opts.Freeze != "", opts.Precopy && opts.Freeze != "":
+		return nil, errors.New("Fork, Precopy and Freeze are mutually exclusive")
+	case opts.Freeze != "":
+		return dumpFrozen(ctx, pid, w, opts)
+	case opts.Fork:
+		return dumpFork(ctx, pid, w, opts)
+	case opts.Precopy:
+		return dumpPrecopy(ctx, pid, w, opts)
+	}
+
+	start := time.Now()
+
+	s, err := ptrace.Seize(pid)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	defer func() {
+		if derr := s.Detach(); err == nil {
+			err = derr
+		}
+		if result != nil {
+			result.Pause = time.Since(start)
+		}
+	}()
+
+	return DumpSession(ctx, s, w, opts)
+}
+
+// errWipedOnFork is the fault recorded for MADV_WIPEONFORK mappings with Fork.
+var errWipedOnFork = errors.New("zeroed in the fork by MADV_WIPEONFORK")
+
+func dumpFork(ctx context.Context, pid int, w io.Writer, opts Options) (result *Result, err error) {
+	start := time.Now()
+
+	s, err := ptrace.Seize(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Detach()
+
+	child, err := s.Fork()
+	if err != nil {
+		return nil, fmt.Errorf("fork: %w", err)
+	}
+
+	defer func() {
+		// pid must be detached for reap to seize it again, which on the
+		// early returns below it still isn't.
+		if derr := s.Detach(); err == nil {
+			err = derr
+		}
+		if rerr := reap(pid, child); err == nil {
+			err = rerr
+		}
+	}()
+
+	d, err := prepare(&target{pid: pid, s: s}, child.Pid(), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+
+	err = s.Detach()
+	if err != nil {
+		return nil, err
+	}
+
+	pause := time.Since(start)
+
+	result, err = d.write(ctx, w)
+	if err != nil {
+		return nil, err
 	}
 
-	notes, err := notes(pid, tids)
+	result.Pause = pause
+
+	return result, nil
+}
+
+// reap kills the child forked by dumpFork, which is a zombie until process pid,
+// its parent, waits for it.  As pid isn't expecting it, gcore briefly seizes
+// pid again to do so.
+func reap(pid int, child *ptrace.Session) error {
+	err := child.Kill()
 	if err != nil {
 		return err
 	}
 
-	progs, err := progs(pid)
+	s, err := ptrace.Seize(pid)
 	if err != nil {
 		return err
 	}
+	defer s.Detach()
+
+	_, err = s.Syscall(unix.SYS_WAIT4, uintptr(child.Pid()), 0, unix.WALL|unix.WNOHANG, 0)
+	if err != nil && err != unix.ECHILD {
+		return fmt.Errorf("reaping %d: %w", child.Pid(), err)
+	}
+
+	return s.Detach()
+}
+
+// Catch seizes process pid and lets it run until one of its threads is about
+// to be killed by a signal whose default action is to dump core.  It then
+// writes a core file to w, recording the signal as the kernel would, and lets
+// the signal take its course.  If ctx is cancelled first, the target is
+// resumed unharmed.
+func Catch(ctx context.Context, pid int, w io.Writer, opts Options) (result *Result, err error) {
+	s, err := ptrace.Seize(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	var start time.Time
+
+	defer func() {
+		if derr := s.Detach(); err == nil {
+			err = derr
+		}
+		if result != nil {
+			result.Pause = time.Since(start)
+		}
+	}()
+
+	tid, err := s.WaitSignal(ctx, func(tid int, sig unix.Signal) bool {
+		return IsFatal(pid, sig)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	start = time.Now()
+
+	result, err = DumpSession(context.Background(), s, w, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Tid, result.Signal = tid, s.Signal(tid)
+
+	return result, nil
+}
+
+// IsFatal reports whether sig will dump process pid's core: its default
+// action must be to dump core, and the process mustn't catch or ignore it.
+func IsFatal(pid int, sig unix.Signal) bool {
+	switch sig {
+	case unix.SIGQUIT, unix.SIGILL, unix.SIGTRAP, unix.SIGABRT, unix.SIGBUS, unix.SIGFPE,
+		unix.SIGSEGV, unix.SIGXCPU, unix.SIGXFSZ, unix.SIGSYS:
+	default:
+		return false
+	}
+
+	status, err := proc.ReadStatus(pid)
+	if err != nil {
+		return false
+	}
+
+	mask := uint64(1) << (sig - 1)
+	return (status.SigCgt|status.SigIgn)&mask == 0
+}
+
+// DumpSession writes a core file of the process seized by s to w, leaving it
+// stopped.  Threads which were about to handle a signal have it recorded.
+func DumpSession(ctx context.Context, s *ptrace.Session, w io.Writer, opts Options) (*Result, error) {
+	d, err := prepare(&target{pid: s.Pid(), s: s}, s.Pid(), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+
+	return d.write(ctx, w)
+}
+
+// A dump is a core file ready to be written, whose notes have been read and
+// whose memory is read while writing.
+type dump struct {
+	f       *elf.File
+	tr      *proc.TolerantReader
+	files   []io.Closer
+	readers int
+}
+
+// prepare reads the notes and memory map of t, but takes its memory from
+// process memPid, which is t itself unless dumping a fork.
+func prepare(t *target, memPid int, opts Options) (_ *dump, err error) {
+	d := &dump{readers: opts.Readers}
+	if d.readers == 0 {
+		d.readers = runtime.GOMAXPROCS(0)
+	}
+
+	defer func() {
+		if err != nil {
+			d.close()
+		}
+	}()
 
-	f := &elf.File{
+	tids, err := t.tids()
+	if err != nil {
+		return nil, err
+	}
+
+	threads, err := selectThreads(tids, opts.Threads)
+	if err != nil {
+		return nil, err
+	}
+
+	sel := opts.Notes
+	if sel == 0 {
+		sel = AllNotes
+	}
+
+	mem, err := proc.NewMemReader(memPid)
+	if err != nil {
+		return nil, err
+	}
+	d.files = append(d.files, mem)
+
+	// Where the kernel refuses other ways of reading memory, the tracer can
+	// still peek at it.
+	if t.s != nil && memPid == t.pid {
+		mem.Peek = t.s.PeekData
+	}
+
+	d.tr = proc.NewTolerantReader(mem)
+
+	var pagemap *os.File
+	if opts.Resident || opts.SkipSwapped {
+		pagemap, err = proc.Pagemap(memPid)
+		if err != nil {
+			return nil, err
+		}
+		d.files = append(d.files, pagemap)
+	}
+
+	progs, regions, err := progs(t.pid, mem, d.tr, pagemap, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := notes(t, threads, regions, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	if sel&NoteFaults != 0 {
+		progs = append(progs, faultsProg(d.tr))
+	}
+
+	d.f = &elf.File{
 		FileHeader: elf.FileHeader{
 			Type: elf.ET_CORE,
 		},
 		Progs: append([]*elf.Prog{notes}, progs...),
 	}
 
-	return pkgelf.Write(os.Stdout, f)
+	return d, nil
+}
+
+func (d *dump) write(ctx context.Context, w io.Writer) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	cw := &ctxWriter{ctx: ctx, w: w}
+
+	err := pkgelf.WriteParallel(cw, d.f, d.readers)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Bytes:  cw.n,
+		Faults: d.tr.Faults(),
+	}
+	for _, prog := range d.f.Progs {
+		result.Segments = append(result.Segments, prog.ProgHeader)
+	}
+
+	return result, nil
+}
+
+func (d *dump) close() {
+	for _, f := range d.files {
+		f.Close()
+	}
+}
+
+func selectThreads(tids, threads []int) ([]int, error) {
+	if len(threads) == 0 {
+		return tids, nil
+	}
+
+	seized := map[int]struct{}{}
+	for _, tid := range tids {
+		seized[tid] = struct{}{}
+	}
+
+	for _, tid := range threads {
+		if _, ok := seized[tid]; !ok {
+			return nil, fmt.Errorf("thread %d not found", tid)
+		}
+	}
+
+	return threads, nil
+}
+
+// ctxWriter counts the bytes written to w and fails once ctx is done.  It is
+// seekable if w is.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+	n   int64
+}
+
+func (w *ctxWriter) Write(b []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *ctxWriter) Seek(offset int64, whence int) (int64, error) {
+	s, ok := w.w.(io.Seeker)
+	if !ok {
+		return 0, errors.New("not seekable")
+	}
+
+	return s.Seek(offset, whence)
 }
@@ -0,0 +1,373 @@
+package gcore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/jim-minter/gcore/pkg/core"
+	"github.com/jim-minter/gcore/pkg/proc"
+	"github.com/jim-minter/gcore/pkg/ptrace"
+)
+
+func startSleep(t *testing.T) int {
+	cmd := exec.Command("sleep", "60")
+
+	err := cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for {
+		stat, err := proc.ReadStat(cmd.Process.Pid, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stat.Comm == "sleep" && stat.State == 'S' {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return cmd.Process.Pid
+}
+
+func tracerPid(t *testing.T, pid int) int {
+	status, err := proc.ReadStatus(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return status.TracerPid
+}
+
+func TestDump(t *testing.T) {
+	pid := startSleep(t)
+
+	buf := &bytes.Buffer{}
+
+	result, err := Dump(context.Background(), pid, buf, Options{})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Bytes != int64(buf.Len()) {
+		t.Errorf("got %d bytes, want %d", result.Bytes, buf.Len())
+	}
+
+	f, err := core.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.ELF.Progs) != len(result.Segments) {
+		t.Errorf("got %d progs, want %d", len(f.ELF.Progs), len(result.Segments))
+	}
+
+	if f.Process == nil || f.Process.Pid != pid || f.Process.Name != "sleep" {
+		t.Errorf("got process %+v", f.Process)
+	}
+
+	if len(f.Threads) != 1 || f.Threads[0].Tid != pid || f.Threads[0].Fpregs == nil || f.Threads[0].Siginfo == nil {
+		t.Errorf("got threads %+v", f.Threads)
+	}
+
+	if len(f.Regions) != len(result.Segments)-2 {
+		t.Errorf("got %d regions, want %d", len(f.Regions), len(result.Segments)-2)
+	}
+
+	for _, th := range f.Threads {
+		b := make([]byte, 8)
+		_, err = f.ReadAt(b, int64(th.Regs.Rsp))
+		if err != nil {
+			t.Errorf("reading stack: %v", err)
+		}
+	}
+
+	if tracer := tracerPid(t, pid); tracer != 0 {
+		t.Errorf("target still traced by %d", tracer)
+	}
+}
+
+func TestDumpCancel(t *testing.T) {
+	pid := startSleep(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := Dump(ctx, pid, &bytes.Buffer{}, Options{})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+	if err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+
+	if tracer := tracerPid(t, pid); tracer != 0 {
+		t.Errorf("target still traced by %d", tracer)
+	}
+}
+
+// waitTraced calls f once pid has been seized.
+func TestDumpFork(t *testing.T) {
+	pid := startSleep(t)
+
+	buf := &bytes.Buffer{}
+
+	result, err := Dump(context.Background(), pid, buf, Options{Fork: true})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Faults) != 0 {
+		t.Errorf("got faults %+v", result.Faults)
+	}
+
+	f, err := core.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The notes describe the parent, as it was before the fork was injected.
+	if f.Process == nil || f.Process.Pid != pid || len(f.Threads) != 1 || f.Threads[0].Tid != pid {
+		t.Fatalf("got process %+v, threads %+v", f.Process, f.Threads)
+	}
+
+	// The memory, taken from the child, matches the parent's: AT_RANDOM points
+	// to 16 random bytes on the stack.
+	var random int64
+	for _, e := range f.Auxv {
+		if e.Name() == "AT_RANDOM" {
+			random = int64(e.Val)
+		}
+	}
+	if random == 0 {
+		t.Fatal("no AT_RANDOM")
+	}
+
+	mem, err := proc.Mem(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	b, want := make([]byte, 16), make([]byte, 16)
+	_, err = f.ReadAt(b, random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mem.ReadAt(want, random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("got random bytes %x, want %x", b, want)
+	}
+
+	_, err = f.ReadAt(b, int64(f.Threads[0].Regs.Rsp))
+	if err != nil {
+		t.Errorf("reading stack: %v", err)
+	}
+
+	if tracer := tracerPid(t, pid); tracer != 0 {
+		t.Errorf("target still traced by %d", tracer)
+	}
+
+	// The child has been killed and reaped.
+	children, err := os.ReadFile(fmt.Sprintf("/proc/%d/task/%d/children", pid, pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 0 {
+		t.Errorf("target has children %q", children)
+	}
+
+	// The target goes back to sleep.
+	time.Sleep(100 * time.Millisecond)
+
+	stat, err := proc.ReadStat(pid, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.State != 'S' {
+		t.Errorf("target in state %c", stat.State)
+	}
+}
+
+func waitTraced(t *testing.T, pid int, f func()) {
+	go func() {
+		for tracerPid(t, pid) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		f()
+	}()
+}
+
+func TestCatch(t *testing.T) {
+	pid := startSleep(t)
+
+	// Stop the kernel writing a core of its own.
+	err := unix.Prlimit(pid, unix.RLIMIT_CORE, &unix.Rlimit{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitTraced(t, pid, func() { syscall.Kill(pid, syscall.SIGABRT) })
+
+	buf := &bytes.Buffer{}
+
+	result, err := Catch(context.Background(), pid, buf, Options{})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Tid != pid || result.Signal != unix.SIGABRT {
+		t.Errorf("got tid %d, signal %v", result.Tid, result.Signal)
+	}
+
+	f, err := core.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Threads) != 1 {
+		t.Fatalf("got %d threads", len(f.Threads))
+	}
+
+	th := f.Threads[0]
+	if th.Cursig != int(unix.SIGABRT) {
+		t.Errorf("got cursig %d", th.Cursig)
+	}
+	if th.Siginfo == nil || th.Siginfo.Signo != int32(unix.SIGABRT) || th.Siginfo.Pid() != os.Getpid() {
+		t.Errorf("got siginfo %+v", th.Siginfo)
+	}
+
+	// The signal is delivered once the target is detached.
+	for {
+		stat, err := proc.ReadStat(pid, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.State == 'Z' {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCatchCancel(t *testing.T) {
+	pid := startSleep(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waitTraced(t, pid, cancel)
+
+	_, err := Catch(ctx, pid, &bytes.Buffer{}, Options{})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+	if err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+
+	if tracer := tracerPid(t, pid); tracer != 0 {
+		t.Errorf("target still traced by %d", tracer)
+	}
+
+	stat, err := proc.ReadStat(pid, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.State == 't' || stat.State == 'T' {
+		t.Errorf("target left stopped")
+	}
+}
+
+func TestDumpGroup(t *testing.T) {
+	pids := []int{startSleep(t), startSleep(t), startSleep(t)}
+
+	sessions, err := ptrace.SeizeAll(func() ([]int, error) { return pids, nil })
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != len(pids) {
+		t.Fatalf("got %d sessions, want %d", len(sessions), len(pids))
+	}
+
+	// Every process is stopped before any is dumped.
+	for _, pid := range pids {
+		stat, err := proc.ReadStat(pid, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.State != 't' {
+			t.Errorf("%d: got state %c", pid, stat.State)
+		}
+	}
+
+	bufs := make([]bytes.Buffer, len(sessions))
+	errs := make([]error, len(sessions))
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		wg.Add(1)
+		go func(i int, s *ptrace.Session) {
+			defer wg.Done()
+			_, errs[i] = DumpSession(context.Background(), s, &bufs[i], Options{})
+		}(i, s)
+	}
+	wg.Wait()
+
+	for i, s := range sessions {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+
+		f, err := core.NewFile(bytes.NewReader(bufs[i].Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.Process == nil || f.Process.Pid != s.Pid() || len(f.Threads) != 1 {
+			t.Errorf("%d: got process %+v and %d threads", s.Pid(), f.Process, len(f.Threads))
+		}
+
+		err = s.Detach()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, pid := range pids {
+		if tracer := tracerPid(t, pid); tracer != 0 {
+			t.Errorf("%d still traced by %d", pid, tracer)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package gcore
+
+import (
+	"context"
+	"debug/elf"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	pkgelf "github.com/jim-minter/gcore/pkg/elf"
+	"github.com/jim-minter/gcore/pkg/proc"
+	"github.com/jim-minter/gcore/pkg/ptrace"
+)
+
+// recopyChunk bounds the size of each read made while re-copying.
+const recopyChunk = 1 << 20
+
+func dumpPrecopy(ctx context.Context, pid int, w io.Writer, opts Options) (result *Result, err error) {
+	err = checkSoftDirty()
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := precopy(ctx, pid, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	start := time.Now()
+
+	s, err := ptrace.Seize(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Detach()
+
+	d, err := prepare(&target{pid: pid, s: s}, pid, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+
+	pagemap, err := proc.Pagemap(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer pagemap.Close()
+
+	recopied, err := st.recopy(ctx, d.f.Progs, pagemap, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Detach()
+	if err != nil {
+		return nil, err
+	}
+
+	pause := time.Since(start)
+
+	result, err = d.write(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Pause, result.Recopied = pause, recopied
+
+	return result, nil
+}
+
+// staging holds memory copied from the target, by address, in an unlinked
+// temporary file.
+type staging struct {
+	f    *os.File
+	size int64
+
+	// ranges are sorted and don't overlap.
+	ranges []stagedRange
+
+	// added are the ranges added by recopy, which are merged into ranges
+	// when it is done.
+	added []stagedRange
+}
+
+type stagedRange struct {
+	start, end uint64
+	off        int64
+}
+
+func newStaging() (*staging, error) {
+	f, err := os.CreateTemp("", "gcore-precopy-")
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Remove(f.Name())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &staging{f: f}, nil
+}
+
+func (st *staging) Close() error {
+	return st.f.Close()
+}
+
+// find returns the index of the first range which ends after addr.
+func (st *staging) find(addr uint64) int {
+	return sort.Search(len(st.ranges), func(i int) bool { return st.ranges[i].end > addr })
+}
+
+// ReadAt reads the memory at address off.  Memory which wasn't copied reads as
+// zeros.
+func (st *staging) ReadAt(p []byte, off int64) (int, error) {
+	addr := uint64(off)
+
+	for n := 0; n < len(p); {
+		m := len(p) - n
+
+		i := st.find(addr)
+		switch {
+		case i < len(st.ranges) && st.ranges[i].start <= addr:
+			r := st.ranges[i]
+			if r.end-addr < uint64(m) {
+				m = int(r.end - addr)
+			}
+
+			_, err := st.f.ReadAt(p[n:n+m], r.off+int64(addr-r.start))
+			if err != nil {
+				return n, err
+			}
+
+		default:
+			if i < len(st.ranges) && st.ranges[i].start-addr < uint64(m) {
+				m = int(st.ranges[i].start - addr)
+			}
+
+			clear(p[n : n+m])
+		}
+
+		n += m
+		addr += uint64(m)
+	}
+
+	return len(p), nil
+}
+
+// write stores b as the memory at addr, overwriting what was copied before.
+func (st *staging) write(addr uint64, b []byte) error {
+	for len(b) > 0 {
+		m := len(b)
+
+		i := st.find(addr)
+		if i < len(st.ranges) && st.ranges[i].start <= addr {
+			r := st.ranges[i]
+			if r.end-addr < uint64(m) {
+				m = int(r.end - addr)
+			}
+
+			_, err := st.f.WriteAt(b[:m], r.off+int64(addr-r.start))
+			if err != nil {
+				return err
+			}
+
+		} else {
+			if i < len(st.ranges) && st.ranges[i].start-addr < uint64(m) {
+				m = int(st.ranges[i].start - addr)
+			}
+
+			_, err := st.f.WriteAt(b[:m], st.size)
+			if err != nil {
+				return err
+			}
+
+			if n := len(st.added); n > 0 && st.added[n-1].end == addr && st.added[n-1].off+int64(st.added[n-1].end-st.added[n-1].start) == st.size {
+				st.added[n-1].end += uint64(m)
+			} else {
+				st.added = append(st.added, stagedRange{start: addr, end: addr + uint64(m), off: st.size})
+			}
+			st.size += int64(m)
+		}
+
+		addr += uint64(m)
+		b = b[m:]
+	}
+
+	return nil
+}
+
+// forget drops the pages touched by faults from the staged ranges, so that
+// they are read again.
+func (st *staging) forget(faults []proc.Fault) {
+	pagesize := uint64(os.Getpagesize())
+
+	sort.Slice(faults, func(i, j int) bool { return faults[i].Start < faults[j].Start })
+
+	var ranges []stagedRange
+	for _, r := range st.ranges {
+		for _, f := range faults {
+			start, end := f.Start&^(pagesize-1), (f.End+pagesize-1)&^(pagesize-1)
+			if end <= r.start || start >= r.end {
+				continue
+			}
+
+			if start > r.start {
+				ranges = append(ranges, stagedRange{start: r.start, end: start, off: r.off})
+			}
+			if end >= r.end {
+				r.start = r.end
+				break
+			}
+
+			r.off += int64(end - r.start)
+			r.start = end
+		}
+
+		if r.start < r.end {
+			ranges = append(ranges, r)
+		}
+	}
+
+	st.ranges = ranges
+}
+
+// precopy clears the soft-dirty bits of running process pid, and then copies
+// the memory which Dump would capture to a new staging file.
+func precopy(ctx context.Context, pid int, opts Options) (_ *staging, err error) {
+	mem, err := proc.NewMemReader(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer mem.Close()
+
+	tr := proc.NewTolerantReader(mem)
+
+	var pagemap *os.File
+	if opts.Resident || opts.SkipSwapped {
+		pagemap, err = proc.Pagemap(pid)
+		if err != nil {
+			return nil, err
+		}
+		defer pagemap.Close()
+	}
+
+	progs, _, err := progs(pid, mem, tr, pagemap, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Anything written from now on, including while copying, is marked
+	// soft-dirty and is copied again once the target is stopped.
+	err = proc.ClearSoftDirty(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := newStaging()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			st.Close()
+		}
+	}()
+
+	for _, prog := range progs {
+		if prog.Filesz == 0 {
+			continue
+		}
+
+		extents := []pkgelf.Extent{{Len: int64(prog.Filesz)}}
+		if sr, ok := prog.ReaderAt.(pkgelf.SparseReaderAt); ok {
+			extents, err = sr.Extents()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, e := range extents {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			_, err = io.Copy(io.NewOffsetWriter(st.f, st.size), io.NewSectionReader(prog.ReaderAt, e.Off, e.Len))
+			if err != nil {
+				return nil, err
+			}
+
+			st.ranges = append(st.ranges, stagedRange{
+				start: prog.Vaddr + uint64(e.Off),
+				end:   prog.Vaddr + uint64(e.Off+e.Len),
+				off:   st.size,
+			})
+			st.size += e.Len
+		}
+	}
+
+	// Pages which couldn't be read are tried again once the target is
+	// stopped, and recorded as faults then if need be.
+	st.forget(tr.Faults())
+
+	return st, nil
+}
+
+// recopy re-reads, from the stopped target, the pages of progs which have been
+// written to since precopy, which weren't copied, or which are no longer
+// resident (e.g. after MADV_DONTNEED, which loses the soft-dirty bit).  It
+// then points progs at the staging file, so that the target can be resumed
+// before they are written, and returns the number of bytes re-read.
+func (st *staging) recopy(ctx context.Context, progs []*elf.Prog, pagemap io.ReaderAt, opts Options) (recopied int64, err error) {
+	pagesize := uint64(os.Getpagesize())
+	resident := opts.Resident || opts.SkipSwapped
+	buf := make([]byte, recopyChunk)
+
+	for _, prog := range progs {
+		if prog.Type != elf.PT_LOAD || prog.Filesz == 0 {
+			continue
+		}
+
+		if err = ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		var extents []pkgelf.Extent
+
+		// [runStart, runEnd) is the run of pages waiting to be re-read.
+		var runStart, runEnd uint64
+		flush := func() error {
+			for runStart < runEnd {
+				n := min(runEnd-runStart, uint64(len(buf)))
+
+				_, err := prog.ReaderAt.ReadAt(buf[:n], int64(runStart-prog.Vaddr))
+				if err != nil {
+					return err
+				}
+
+				err = st.write(runStart, buf[:n])
+				if err != nil {
+					return err
+				}
+
+				recopied += int64(n)
+				runStart += n
+			}
+
+			return nil
+		}
+
+		end := prog.Vaddr + prog.Filesz
+		for start := prog.Vaddr; start < end; start += pagemapChunk * pagesize {
+			entries, err := proc.ReadPagemap(pagemap, start, min(start+pagemapChunk*pagesize, end))
+			if err != nil {
+				return 0, err
+			}
+
+			for i, e := range entries {
+				addr := start + uint64(i)*pagesize
+
+				if resident && !e.Present() && (!e.Swapped() || opts.SkipSwapped) {
+					continue
+				}
+
+				off := int64(addr - prog.Vaddr)
+				if n := len(extents); n > 0 && extents[n-1].Off+extents[n-1].Len == off {
+					extents[n-1].Len += int64(pagesize)
+				} else {
+					extents = append(extents, pkgelf.Extent{Off: off, Len: int64(pagesize)})
+				}
+
+				if i := st.find(addr); i < len(st.ranges) && st.ranges[i].start <= addr &&
+					!e.SoftDirty() && (e.Present() || e.Swapped()) {
+					continue
+				}
+
+				if runEnd != addr {
+					if err = flush(); err != nil {
+						return 0, err
+					}
+					runStart = addr
+				}
+				runEnd = addr + pagesize
+			}
+		}
+
+		if err = flush(); err != nil {
+			return 0, err
+		}
+
+		prog.ReaderAt = &stagedReader{
+			SectionReader: io.NewSectionReader(st, int64(prog.Vaddr), int64(prog.Filesz)),
+			extents:       extents,
+		}
+	}
+
+	st.ranges = append(st.ranges, st.added...)
+	st.added = nil
+	sort.Slice(st.ranges, func(i, j int) bool { return st.ranges[i].start < st.ranges[j].start })
+
+	return recopied, nil
+}
+
+// stagedReader reads a mapping's contents from the staging file.
+type stagedReader struct {
+	*io.SectionReader
+	extents []pkgelf.Extent
+}
+
+func (r *stagedReader) Extents() ([]pkgelf.Extent, error) {
+	return r.extents, nil
+}
+
+var softDirty struct {
+	once sync.Once
+	err  error
+}
+
+// checkSoftDirty returns an error unless the kernel tracks soft-dirty pages,
+// which it only does if built with CONFIG_MEM_SOFT_DIRTY.  Otherwise pagemap
+// never reports a page as soft-dirty, which precopy would take to mean that
+// nothing had changed.
+func checkSoftDirty() error {
+	softDirty.once.Do(func() {
+		pagesize := os.Getpagesize()
+
+		b, err := unix.Mmap(-1, 0, pagesize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+		if err != nil {
+			softDirty.err = err
+			return
+		}
+		defer unix.Munmap(b)
+
+		err = proc.ClearSoftDirty(os.Getpid())
+		if err != nil {
+			softDirty.err = err
+			return
+		}
+
+		b[0] = 1
+
+		pagemap, err := proc.Pagemap(os.Getpid())
+		if err != nil {
+			softDirty.err = err
+			return
+		}
+		defer pagemap.Close()
+
+		addr := uint64(uintptr(unsafe.Pointer(&b[0])))
+		entries, err := proc.ReadPagemap(pagemap, addr, addr+uint64(pagesize))
+		if err != nil {
+			softDirty.err = err
+			return
+		}
+
+		if !entries[0].SoftDirty() {
+			softDirty.err = errors.New("the kernel doesn't track soft-dirty pages")
+		}
+	})
+
+	return softDirty.err
+}
@@ -0,0 +1,142 @@
+package gcore
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/jim-minter/gcore/pkg/core"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+func TestRecopy(t *testing.T) {
+	pagesize := uint64(os.Getpagesize())
+	vaddr := 16 * pagesize
+
+	page := func(c byte) []byte { return bytes.Repeat([]byte{c}, int(pagesize)) }
+
+	st, err := newStaging()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	// Pages 0-2 were copied while running, and page 1 faulted.
+	for i := uint64(0); i < 3; i++ {
+		err = st.write(vaddr+i*pagesize, page('a'))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	st.ranges, st.added = st.added, nil
+	st.forget([]proc.Fault{{Start: vaddr + pagesize + 1, End: vaddr + pagesize + 2}})
+
+	if len(st.ranges) != 2 {
+		t.Fatalf("got ranges %+v", st.ranges)
+	}
+
+	// Page 0 is clean, 1 wasn't copied, 2 is dirty, 3 wasn't copied and 4
+	// isn't resident.
+	const (
+		present   = 1 << 63
+		softDirty = 1 << 55
+	)
+	entries := []uint64{present, present, present | softDirty, present, 0}
+
+	pagemap := make([]byte, (16+len(entries))*8)
+	for i, e := range entries {
+		binary.LittleEndian.PutUint64(pagemap[(16+i)*8:], e)
+	}
+
+	live := bytes.Join([][]byte{page('b'), page('c'), page('d'), page('e'), page('f')}, nil)
+	prog := &elf.Prog{
+		ProgHeader: elf.ProgHeader{
+			Type:   elf.PT_LOAD,
+			Vaddr:  vaddr,
+			Filesz: uint64(len(live)),
+		},
+		ReaderAt: bytes.NewReader(live),
+	}
+
+	recopied, err := st.recopy(context.Background(), []*elf.Prog{prog}, bytes.NewReader(pagemap), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recopied != int64(4*pagesize) {
+		t.Errorf("recopied %d bytes, want %d", recopied, 4*pagesize)
+	}
+
+	got, err := io.ReadAll(prog.ReaderAt.(io.Reader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := bytes.Join([][]byte{page('a'), page('c'), page('d'), page('e'), page('f')}, nil)
+	if !bytes.Equal(got, want) {
+		t.Error("wrong contents")
+	}
+
+	// With Resident, the page which isn't resident is left as a hole.
+	prog.ReaderAt = bytes.NewReader(live)
+	_, err = st.recopy(context.Background(), []*elf.Prog{prog}, bytes.NewReader(pagemap), Options{Resident: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extents, err := prog.ReaderAt.(*stagedReader).Extents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extents) != 1 || extents[0].Off != 0 || extents[0].Len != int64(4*pagesize) {
+		t.Errorf("got extents %+v", extents)
+	}
+}
+
+func TestDumpPrecopy(t *testing.T) {
+	if err := checkSoftDirty(); err != nil {
+		t.Skip(err)
+	}
+
+	pid := startSleep(t)
+
+	buf := &bytes.Buffer{}
+
+	result, err := Dump(context.Background(), pid, buf, Options{Precopy: true})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := core.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Process == nil || f.Process.Pid != pid || len(f.Threads) != 1 {
+		t.Fatalf("got process %+v, threads %+v", f.Process, f.Threads)
+	}
+
+	b := make([]byte, 8)
+	_, err = f.ReadAt(b, int64(f.Threads[0].Regs.Rsp))
+	if err != nil {
+		t.Errorf("reading stack: %v", err)
+	}
+
+	// sleep doesn't write much while it sleeps.
+	if result.Recopied > 1<<20 {
+		t.Errorf("recopied %d bytes", result.Recopied)
+	}
+
+	if tracer := tracerPid(t, pid); tracer != 0 {
+		t.Errorf("target still traced by %d", tracer)
+	}
+}
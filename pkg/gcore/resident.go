@@ -0,0 +1,58 @@
+package gcore
+
+import (
+	"io"
+	"os"
+
+	pkgelf "github.com/jim-minter/gcore/pkg/elf"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+// pagemapChunk bounds the number of pagemap entries read at once.
+const pagemapChunk = 1 << 16
+
+// residentReader reads a mapping's contents, but reports only the pages which
+// are present (or swapped, unless skipSwapped is set) as containing data, so
+// that never-touched pages aren't faulted in and written out.
+type residentReader struct {
+	*io.SectionReader
+	pagemap     io.ReaderAt
+	start       uint64
+	skipSwapped bool
+}
+
+func (r *residentReader) Extents() (extents []pkgelf.Extent, err error) {
+	pagesize := uint64(os.Getpagesize())
+	end := r.start + uint64(r.Size())
+
+	for start := r.start; start < end; start += pagemapChunk * pagesize {
+		entries, err := proc.ReadPagemap(r.pagemap, start, min(start+pagemapChunk*pagesize, end))
+		if err != nil {
+			return nil, err
+		}
+
+		for i, e := range entries {
+			if !e.Present() && (!e.Swapped() || r.skipSwapped) {
+				continue
+			}
+
+			off := int64(start - r.start + uint64(i)*pagesize)
+
+			if len(extents) > 0 && extents[len(extents)-1].Off+extents[len(extents)-1].Len == off {
+				extents[len(extents)-1].Len += int64(pagesize)
+			} else {
+				extents = append(extents, pkgelf.Extent{Off: off, Len: int64(pagesize)})
+			}
+		}
+	}
+
+	return extents, nil
+}
+
+func min(i, j uint64) uint64 {
+	if i < j {
+		return i
+	}
+
+	return j
+}
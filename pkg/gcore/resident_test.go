@@ -0,0 +1,104 @@
+package gcore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	pkgelf "github.com/jim-minter/gcore/pkg/elf"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+func TestResidentReaderExtents(t *testing.T) {
+	pagesize := os.Getpagesize()
+
+	b, err := unix.Mmap(-1, 0, 8*pagesize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(b)
+
+	for _, page := range []int{1, 2, 5} {
+		b[page*pagesize] = 1
+	}
+
+	pagemap, err := proc.Pagemap(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pagemap.Close()
+
+	r := &residentReader{
+		SectionReader: io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))),
+		pagemap:       pagemap,
+		start:         uint64(uintptr(unsafe.Pointer(&b[0]))),
+	}
+
+	extents, err := r.Extents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []pkgelf.Extent{
+		{Off: int64(pagesize), Len: int64(2 * pagesize)},
+		{Off: int64(5 * pagesize), Len: int64(pagesize)},
+	}
+	if !reflect.DeepEqual(extents, want) {
+		t.Errorf("got extents %+v, want %+v", extents, want)
+	}
+}
+
+func TestResidentReaderSkipSwapped(t *testing.T) {
+	pagesize := os.Getpagesize()
+
+	const (
+		present = 1 << 63
+		swapped = 1 << 62
+	)
+
+	// A fake pagemap for a mapping at 0: present, swapped, neither, present.
+	pagemap := make([]byte, 4*8)
+	for i, e := range []uint64{present, swapped, 0, present} {
+		binary.LittleEndian.PutUint64(pagemap[i*8:], e)
+	}
+
+	for _, tt := range []struct {
+		skipSwapped bool
+		want        []pkgelf.Extent
+	}{
+		{
+			want: []pkgelf.Extent{
+				{Off: 0, Len: int64(2 * pagesize)},
+				{Off: int64(3 * pagesize), Len: int64(pagesize)},
+			},
+		},
+		{
+			skipSwapped: true,
+			want: []pkgelf.Extent{
+				{Off: 0, Len: int64(pagesize)},
+				{Off: int64(3 * pagesize), Len: int64(pagesize)},
+			},
+		},
+	} {
+		r := &residentReader{
+			SectionReader: io.NewSectionReader(bytes.NewReader(nil), 0, int64(4*pagesize)),
+			pagemap:       bytes.NewReader(pagemap),
+			skipSwapped:   tt.skipSwapped,
+		}
+
+		extents, err := r.Extents()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(extents, tt.want) {
+			t.Errorf("skipSwapped %v: got extents %+v, want %+v", tt.skipSwapped, extents, tt.want)
+		}
+	}
+}
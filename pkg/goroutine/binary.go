@@ -0,0 +1,333 @@
+package goroutine
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/gosym"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// field is the offset of a runtime struct field, or -1 if the target's Go
+// version doesn't have it.
+type field int64
+
+// layout holds the offsets of the runtime struct fields which are read.  They
+// are taken from the binary's DWARF so that they track the Go version, or, if
+// it was linked without DWARF (-ldflags=-w), from layouts.
+type layout struct {
+	gStackLo    field
+	gStackHi    field
+	gM          field
+	gSchedSP    field
+	gSchedPC    field
+	gStatus     field
+	gGoid       field
+	gWaitReason field
+	gParentGoid field
+	gGopc       field
+	gStartpc    field
+	mProcid     field
+}
+
+// layouts are the runtime struct layouts of each supported Go release on amd64,
+// as found in the DWARF of binaries built by its latest patch release.
+var layouts = map[string]*layout{
+	"go1.20": {
+		gStackLo: 0, gStackHi: 8, gM: 48, gSchedSP: 56, gSchedPC: 64,
+		gStatus: 144, gGoid: 152, gWaitReason: 176, gParentGoid: -1, gGopc: 296, gStartpc: 312,
+		mProcid: 72,
+	},
+	"go1.21": {
+		gStackLo: 0, gStackHi: 8, gM: 48, gSchedSP: 56, gSchedPC: 64,
+		gStatus: 144, gGoid: 152, gWaitReason: 176, gParentGoid: 272, gGopc: 280, gStartpc: 296,
+		mProcid: 72,
+	},
+	"go1.22": {
+		gStackLo: 0, gStackHi: 8, gM: 48, gSchedSP: 56, gSchedPC: 64,
+		gStatus: 144, gGoid: 152, gWaitReason: 176, gParentGoid: 272, gGopc: 280, gStartpc: 296,
+		mProcid: 72,
+	},
+	"go1.23": {
+		gStackLo: 0, gStackHi: 8, gM: 48, gSchedSP: 56, gSchedPC: 64,
+		gStatus: 152, gGoid: 160, gWaitReason: 184, gParentGoid: 280, gGopc: 288, gStartpc: 304,
+		mProcid: 72,
+	},
+	"go1.24": {
+		gStackLo: 0, gStackHi: 8, gM: 48, gSchedSP: 56, gSchedPC: 64,
+		gStatus: 152, gGoid: 160, gWaitReason: 184, gParentGoid: 280, gGopc: 288, gStartpc: 304,
+		mProcid: 72,
+	},
+	"go1.25": {
+		gStackLo: 0, gStackHi: 8, gM: 48, gSchedSP: 56, gSchedPC: 64,
+		gStatus: 144, gGoid: 152, gWaitReason: 176, gParentGoid: 272, gGopc: 280, gStartpc: 296,
+		mProcid: 64,
+	},
+	"go1.26": {
+		gStackLo: 0, gStackHi: 8, gM: 48, gSchedSP: 56, gSchedPC: 64,
+		gStatus: 144, gGoid: 152, gWaitReason: 176, gParentGoid: 280, gGopc: 288, gStartpc: 304,
+		mProcid: 64,
+	},
+	"go1.27": {
+		gStackLo: 0, gStackHi: 8, gM: 48, gSchedSP: 56, gSchedPC: 64,
+		gStatus: 144, gGoid: 152, gWaitReason: 176, gParentGoid: 280, gGopc: 288, gStartpc: 304,
+		mProcid: 64,
+	},
+}
+
+// releaseRx matches the Go release in a runtime.buildVersion, e.g. go1.22 in
+// go1.22.12 or go1.23rc1.
+var releaseRx = regexp.MustCompile(`^go1\.[0-9]+`)
+
+// builtinLayout returns the runtime struct layout of Go version, a
+// runtime.buildVersion, from layouts.
+func builtinLayout(version string) (*layout, error) {
+	if l, ok := layouts[releaseRx.FindString(version)]; ok {
+		return l, nil
+	}
+
+	return nil, fmt.Errorf("the binary has no DWARF, and %s has no built-in runtime layout", version)
+}
+
+// A goBinary is the executable of a Go process.
+type goBinary struct {
+	bias  uint64
+	syms  map[string]elf.Symbol
+	table *gosym.Table
+	pcln  *pclntab
+
+	// layout is nil until the Go version is known if the binary has no
+	// DWARF.
+	layout *layout
+}
+
+// openBinary reads the symbol table, pclntab and, if present, DWARF runtime
+// struct layouts of f, an executable loaded at bias.
+func openBinary(f *elf.File, bias uint64) (*goBinary, error) {
+	b := &goBinary{bias: bias, syms: map[string]elf.Symbol{}}
+
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, fmt.Errorf("reading symbols: %v", err)
+	}
+	for _, sym := range syms {
+		b.syms[sym.Name] = sym
+	}
+
+	for _, name := range []string{"runtime.allgs", "runtime.buildVersion"} {
+		if _, ok := b.syms[name]; !ok {
+			return nil, fmt.Errorf("symbol %s not found: not a Go executable?", name)
+		}
+	}
+
+	s := f.Section(".gopclntab")
+	if s == nil {
+		return nil, errors.New(".gopclntab section not found")
+	}
+	pclntab, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	text := b.syms["runtime.text"].Value
+	if text == 0 {
+		if s := f.Section(".text"); s != nil {
+			text = s.Addr
+		}
+	}
+
+	b.table, err = gosym.NewTable(nil, gosym.NewLineTable(pclntab, text))
+	if err != nil {
+		return nil, fmt.Errorf("reading pclntab: %v", err)
+	}
+
+	b.pcln, err = newPclntab(pclntab, text)
+	if err != nil {
+		return nil, fmt.Errorf("reading pclntab: %v", err)
+	}
+
+	if d, err := f.DWARF(); err == nil {
+		b.layout, err = readLayout(d)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+func readLayout(d *dwarf.Data) (*layout, error) {
+	structs := map[string]map[string]field{
+		"runtime.g":     nil,
+		"runtime.m":     nil,
+		"runtime.gobuf": nil,
+		"runtime.stack": nil,
+	}
+
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+
+		name, _ := e.Val(dwarf.AttrName).(string)
+		fields, want := structs[name]
+		if e.Tag != dwarf.TagStructType || !want || fields != nil {
+			if e.Children && e.Tag != dwarf.TagCompileUnit {
+				r.SkipChildren()
+			}
+			continue
+		}
+
+		fields = map[string]field{}
+		for e.Children {
+			c, err := r.Next()
+			if err != nil {
+				return nil, err
+			}
+			if c == nil || c.Tag == 0 {
+				break
+			}
+			if c.Tag != dwarf.TagMember {
+				if c.Children {
+					r.SkipChildren()
+				}
+				continue
+			}
+
+			name, _ := c.Val(dwarf.AttrName).(string)
+			if off, ok := c.Val(dwarf.AttrDataMemberLoc).(int64); ok {
+				fields[name] = field(off)
+			}
+		}
+		structs[name] = fields
+	}
+
+	var missing []string
+	get := func(typ string, path ...string) field {
+		var off field
+		for i, name := range path {
+			f, ok := structs[typ][name]
+			if !ok {
+				missing = append(missing, typ+"."+name)
+				return -1
+			}
+			off += f
+
+			if i < len(path)-1 {
+				typ = map[string]string{"stack": "runtime.stack", "sched": "runtime.gobuf"}[name]
+			}
+		}
+		return off
+	}
+	optional := func(typ, name string) field {
+		if f, ok := structs[typ][name]; ok {
+			return f
+		}
+		return -1
+	}
+
+	l := &layout{
+		gStackLo:    get("runtime.g", "stack", "lo"),
+		gStackHi:    get("runtime.g", "stack", "hi"),
+		gM:          get("runtime.g", "m"),
+		gSchedSP:    get("runtime.g", "sched", "sp"),
+		gSchedPC:    get("runtime.g", "sched", "pc"),
+		gStatus:     get("runtime.g", "atomicstatus"),
+		gGoid:       get("runtime.g", "goid"),
+		gWaitReason: get("runtime.g", "waitreason"),
+		gParentGoid: optional("runtime.g", "parentGoid"),
+		gGopc:       get("runtime.g", "gopc"),
+		gStartpc:    get("runtime.g", "startpc"),
+		mProcid:     get("runtime.m", "procid"),
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("runtime struct fields not found in DWARF: %v", missing)
+	}
+
+	return l, nil
+}
+
+// symbol returns the run-time address of the named symbol.
+func (b *goBinary) symbol(name string) (uint64, bool) {
+	sym, ok := b.syms[name]
+	if !ok {
+		return 0, false
+	}
+	return sym.Value + b.bias, true
+}
+
+// function returns the function containing pc, and pc's file and line.
+func (b *goBinary) function(pc uint64) (*gosym.Func, string, int) {
+	file, line, fn := b.table.PCToLine(pc - b.bias)
+	return fn, file, line
+}
+
+// memory reads fixed size values from a target's address space.
+type memory struct {
+	io.ReaderAt
+}
+
+func (m memory) read(addr uint64, n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := m.ReadAt(b, int64(addr))
+	if err != nil {
+		return nil, fmt.Errorf("reading %#x: %v", addr, err)
+	}
+	return b, nil
+}
+
+func (m memory) uint8(addr uint64, off field) (uint8, error) {
+	b, err := m.read(addr+uint64(off), 1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (m memory) uint32(addr uint64, off field) (uint32, error) {
+	b, err := m.read(addr+uint64(off), 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (m memory) uint64(addr uint64, off field) (uint64, error) {
+	b, err := m.read(addr+uint64(off), 8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// string reads a Go string header at addr and the string it points to.
+func (m memory) string(addr uint64) (string, error) {
+	b, err := m.read(addr, 16)
+	if err != nil {
+		return "", err
+	}
+
+	p, n := binary.LittleEndian.Uint64(b), binary.LittleEndian.Uint64(b[8:])
+	if n == 0 {
+		return "", nil
+	}
+	if n > 1<<16 {
+		return "", fmt.Errorf("string at %#x is implausibly long", addr)
+	}
+
+	b, err = m.read(p, int(n))
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,123 @@
+package goroutine
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/jim-minter/gcore/pkg/core"
+	"github.com/jim-minter/gcore/pkg/stack"
+)
+
+// FromCore reads the goroutines of the Go process in core c.  exe is the
+// process's executable; if empty, the path recorded in the core's NT_FILE note
+// is used.
+func FromCore(c *core.File, exe string) (*Result, error) {
+	var entry uint64
+	for _, e := range c.Auxv {
+		if e.Type == atEntry {
+			entry = e.Val
+		}
+	}
+
+	if exe == "" {
+		for _, m := range c.Files {
+			if entry >= m.Start && entry < m.End {
+				exe = m.Path
+				break
+			}
+		}
+		if exe == "" {
+			return nil, errors.New("executable not found in core")
+		}
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := openBinary(f, entry-f.Entry)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", exe, err)
+	}
+
+	ms := stack.NewModules()
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_X != 0 {
+			ms.Add(exe, f, prog.Vaddr+b.bias, prog.Vaddr+prog.Memsz+b.bias, b.bias)
+		}
+	}
+
+	t := &target{
+		b:       b,
+		mem:     memory{&overlay{c: c, f: f, bias: b.bias}},
+		ms:      ms,
+		threads: map[int]*unix.PtraceRegs{},
+	}
+
+	for _, th := range c.Threads {
+		// core.Regs and unix.PtraceRegs are both struct user_regs_struct.
+		t.threads[th.Tid] = (*unix.PtraceRegs)(unsafe.Pointer(&th.Regs))
+	}
+
+	err = t.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	gs, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.result(gs), nil
+}
+
+// overlay reads memory from a core, falling back to the executable for
+// file-backed pages which weren't captured, such as the text and unmodified
+// data.
+type overlay struct {
+	c    *core.File
+	f    *elf.File
+	bias uint64
+}
+
+func (o *overlay) ReadAt(p []byte, addr int64) (int, error) {
+	n, err := o.c.ReadAt(p, addr)
+	if !errors.Is(err, core.ErrOmitted) && !errors.Is(err, core.ErrUnmapped) {
+		return n, err
+	}
+
+	a := uint64(addr) - o.bias
+	for _, prog := range o.f.Progs {
+		if prog.Type != elf.PT_LOAD || a < prog.Vaddr || a+uint64(len(p)) > prog.Vaddr+prog.Memsz {
+			continue
+		}
+
+		// Beyond Filesz, e.g. .bss, is zero until written.
+		for i := range p {
+			p[i] = 0
+		}
+
+		off := a - prog.Vaddr
+		if off < prog.Filesz {
+			end := uint64(len(p))
+			if off+end > prog.Filesz {
+				end = prog.Filesz - off
+			}
+			_, err := prog.ReadAt(p[:end], int64(off))
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		return len(p), nil
+	}
+
+	return n, err
+}
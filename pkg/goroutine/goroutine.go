@@ -0,0 +1,446 @@
+// Package goroutine lists the goroutines of a Go process and their stacks,
+// either live or from a core.
+package goroutine
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/jim-minter/gcore/pkg/proc"
+	"github.com/jim-minter/gcore/pkg/ptrace"
+	"github.com/jim-minter/gcore/pkg/stack"
+)
+
+// Goroutine statuses, from runtime/runtime2.go.
+const (
+	gRunning   = 2
+	gSyscall   = 3
+	gWaiting   = 4
+	gDead      = 6
+	gLeaked    = 10
+	gDeadExtra = 11
+	gScan      = 0x1000
+)
+
+const (
+	// maxAllglen bounds the goroutines read from a corrupt runtime.allgs.
+	maxAllglen = 1 << 24
+
+	// maxFrames bounds the frames unwound from a corrupt stack.
+	maxFrames = 1 << 10
+
+	atEntry = 9
+)
+
+var statuses = []string{
+	0:          "idle",
+	1:          "runnable",
+	gRunning:   "running",
+	gSyscall:   "syscall",
+	gWaiting:   "waiting",
+	gDead:      "dead",
+	8:          "copystack",
+	9:          "preempted",
+	gLeaked:    "leaked",
+	gDeadExtra: "waiting for cgo callback",
+}
+
+type Frame struct {
+	PC       uint64
+	Function string
+	File     string
+	Line     int
+
+	// Offset is PC's offset from the function's entry.
+	Offset uint64
+}
+
+type Goroutine struct {
+	ID     uint64
+	Status string
+
+	// Tid is the thread running the goroutine, if any.
+	Tid int
+
+	// System is set for the runtime's own goroutines, which GOTRACEBACK=all
+	// omits.
+	System bool
+
+	Frames []Frame
+
+	// CreatedBy is the go statement which started the goroutine, executed by
+	// goroutine ParentID if known.
+	CreatedBy *Frame
+	ParentID  uint64
+
+	// Err is set if the stack couldn't be read in full.
+	Err error
+
+	pcs     []uint64
+	gopc    uint64
+	startpc uint64
+}
+
+type Result struct {
+	GoVersion  string
+	Goroutines []*Goroutine
+}
+
+// A target is a Go process's memory and threads, live or in a core.
+type target struct {
+	b       *goBinary
+	mem     memory
+	ms      *stack.Modules
+	threads map[int]*unix.PtraceRegs
+
+	version     string
+	waitReasons []string
+}
+
+// Dump stops process pid, reads its goroutines' stacks and resumes it.  As
+// with stack.Trace, the executable is read before the process is stopped and
+// frames are symbolized after it resumes.
+func Dump(pid int) (result *Result, err error) {
+	mem, err := proc.Mem(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer mem.Close()
+
+	f, err := elf.Open(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	auxv, err := proc.ReadAuxv(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry uint64
+	for i := 0; i+16 <= len(auxv); i += 16 {
+		if binary.LittleEndian.Uint64(auxv[i:]) == atEntry {
+			entry = binary.LittleEndian.Uint64(auxv[i+8:])
+		}
+	}
+
+	b, err := openBinary(f, entry-f.Entry)
+	if err != nil {
+		return nil, err
+	}
+
+	ms, err := stack.LoadModules(pid, mem)
+	if err != nil {
+		return nil, err
+	}
+	defer ms.Close()
+
+	ms.LoadFrames()
+
+	t := &target{b: b, mem: memory{mem}, ms: ms, threads: map[int]*unix.PtraceRegs{}}
+
+	err = t.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	gs, err := t.capture(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.result(gs), nil
+}
+
+func (t *target) capture(pid int) (gs []*Goroutine, err error) {
+	s, err := ptrace.Seize(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if derr := s.Detach(); err == nil {
+			err = derr
+		}
+	}()
+
+	for _, tid := range s.Tids() {
+		regs, err := ptrace.GetRegs(pid, tid)
+		if err != nil {
+			return nil, err
+		}
+		t.threads[tid] = regs
+	}
+
+	return t.read()
+}
+
+// prepare reads the Go version and wait reasons, which don't change while the
+// target runs, and picks the runtime struct layout for the version if the
+// binary has no DWARF.
+func (t *target) prepare() error {
+	addr, _ := t.b.symbol("runtime.buildVersion")
+	version, err := t.mem.string(addr)
+	if err != nil {
+		return fmt.Errorf("reading runtime.buildVersion: %v", err)
+	}
+	t.version = version
+
+	if t.b.layout == nil {
+		t.b.layout, err = builtinLayout(version)
+		if err != nil {
+			return err
+		}
+	}
+
+	if sym, ok := t.b.syms["runtime.waitReasonStrings"]; ok {
+		for i := uint64(0); i < sym.Size/16; i++ {
+			s, err := t.mem.string(sym.Value + t.b.bias + i*16)
+			if err != nil {
+				return fmt.Errorf("reading runtime.waitReasonStrings: %v", err)
+			}
+			t.waitReasons = append(t.waitReasons, s)
+		}
+	}
+
+	return nil
+}
+
+// read walks runtime.allgs, reading each goroutine and unwinding its stack.
+// Frames are symbolized later, by result.
+func (t *target) read() ([]*Goroutine, error) {
+	l := t.b.layout
+
+	addr, _ := t.b.symbol("runtime.allgs")
+	allgs, err := t.mem.uint64(addr, 0)
+	if err != nil {
+		return nil, err
+	}
+	allglen, err := t.mem.uint64(addr, 8)
+	if err != nil {
+		return nil, err
+	}
+	if allglen > maxAllglen {
+		return nil, fmt.Errorf("runtime.allgs has implausible length %d", allglen)
+	}
+
+	var gs []*Goroutine
+
+	for i := uint64(0); i < allglen; i++ {
+		gp, err := t.mem.uint64(allgs+i*8, 0)
+		if err != nil {
+			return nil, err
+		}
+		if gp == 0 {
+			continue
+		}
+
+		status, err := t.mem.uint32(gp, l.gStatus)
+		if err != nil {
+			return nil, err
+		}
+		status &^= gScan
+		if status == gDead || status == gDeadExtra {
+			continue
+		}
+
+		g := &Goroutine{Status: "???"}
+		if int(status) < len(statuses) && statuses[status] != "" {
+			g.Status = statuses[status]
+		}
+
+		var fields [5]uint64
+		for i, off := range []field{l.gGoid, l.gM, l.gGopc, l.gStartpc, l.gParentGoid} {
+			if off < 0 {
+				continue
+			}
+			fields[i], err = t.mem.uint64(gp, off)
+			if err != nil {
+				return nil, err
+			}
+		}
+		g.ID, g.gopc, g.startpc, g.ParentID = fields[0], fields[2], fields[3], fields[4]
+		m := fields[1]
+
+		if status == gWaiting || status == gLeaked {
+			reason, err := t.mem.uint8(gp, l.gWaitReason)
+			if err != nil {
+				return nil, err
+			}
+			if reason != 0 && int(reason) < len(t.waitReasons) {
+				g.Status = t.waitReasons[reason]
+			}
+		}
+
+		if (status == gRunning || status == gSyscall) && m != 0 {
+			procid, err := t.mem.uint64(m, l.mProcid)
+			if err != nil {
+				return nil, err
+			}
+			g.Tid = int(procid)
+		}
+
+		g.Err = t.unwind(gp, status, g)
+
+		gs = append(gs, g)
+	}
+
+	return gs, nil
+}
+
+// unwind records the return addresses on g's stack.  A running goroutine is
+// unwound from its thread's registers, unless the thread has switched to a
+// system stack, in which case the goroutine's saved context is used as it is
+// for any other.  Go frames are unwound with the pclntab; a running goroutine
+// stopped outside Go code, e.g. in C called through cgo, is unwound with its
+// thread's CFI instead.
+func (t *target) unwind(gp uint64, status uint32, g *Goroutine) error {
+	l := t.b.layout
+
+	var v [4]uint64
+	for i, off := range []field{l.gStackLo, l.gStackHi, l.gSchedPC, l.gSchedSP} {
+		var err error
+		v[i], err = t.mem.uint64(gp, off)
+		if err != nil {
+			return err
+		}
+	}
+	lo, hi, pc, sp := v[0], v[1], v[2], v[3]
+
+	regs := t.threads[g.Tid]
+
+	switch {
+	case status == gRunning && regs == nil:
+		return fmt.Errorf("goroutine running on thread %d, whose registers are unavailable", g.Tid)
+	case status == gRunning && regs.Rsp >= lo && regs.Rsp < hi:
+		if t.b.pcln.find(regs.Rip-t.b.bias) == nil {
+			frames, err := t.ms.Unwind(t.mem, regs)
+			for _, f := range frames {
+				g.pcs = append(g.pcs, f.PC)
+			}
+			return err
+		}
+		pc, sp = regs.Rip, regs.Rsp
+	case pc == 0:
+		return errors.New("goroutine has no saved context")
+	}
+
+	// As in the runtime's own tracebacks, a saved pc is treated as exact:
+	// it's either just after a call, or a new goroutine's entry.
+	var err error
+	g.pcs, err = t.unwindGo(pc, sp, hi)
+	return err
+}
+
+// unwindGo returns the return addresses on a goroutine stack ending at hi,
+// starting from pc and sp, using the pcsp tables of the functions' pclntab
+// entries to find each frame's size.  It stops at runtime.goexit.
+func (t *target) unwindGo(pc, sp, hi uint64) ([]uint64, error) {
+	goexit, _ := t.b.symbol("runtime.goexit")
+
+	var pcs []uint64
+
+	for len(pcs) < maxFrames {
+		pcs = append(pcs, pc)
+
+		// Return addresses are looked up by their call instruction, which
+		// may be the last in its function.
+		lookup := pc - t.b.bias
+		if len(pcs) > 1 {
+			lookup--
+		}
+
+		f := t.b.pcln.find(lookup)
+		if f == nil {
+			return pcs, fmt.Errorf("no Go function at %#x", pc)
+		}
+		if f.entry+t.b.bias == goexit {
+			return pcs, nil
+		}
+
+		delta, err := t.b.pcln.spdelta(f, lookup)
+		if err != nil {
+			return pcs, err
+		}
+
+		sp += delta
+		if sp+8 > hi {
+			return pcs, fmt.Errorf("frame at %#x runs off the stack", pc)
+		}
+
+		pc, err = t.mem.uint64(sp, 0)
+		if err != nil {
+			return pcs, err
+		}
+		sp += 8
+
+		if pc == 0 {
+			return pcs, nil
+		}
+	}
+
+	return pcs, fmt.Errorf("stack deeper than %d frames", maxFrames)
+}
+
+// result symbolizes the goroutines' frames.
+func (t *target) result(gs []*Goroutine) *Result {
+	for _, g := range gs {
+		for i, pc := range g.pcs {
+			f := t.frame(pc, i > 0)
+
+			// runtime.goexit is the return address planted at the base of
+			// each goroutine's stack.
+			if strings.HasPrefix(f.Function, "runtime.goexit") {
+				break
+			}
+
+			g.Frames = append(g.Frames, f)
+		}
+
+		if g.gopc != 0 {
+			f := t.frame(g.gopc, true)
+			g.CreatedBy = &f
+		}
+
+		if fn, _, _ := t.b.function(g.startpc); fn != nil {
+			g.System = isSystem(fn.Name)
+		}
+	}
+
+	return &Result{GoVersion: t.version, Goroutines: gs}
+}
+
+// frame symbolizes pc.  call is set if pc is a return address, in which case
+// the call instruction is looked up.
+func (t *target) frame(pc uint64, call bool) Frame {
+	f := Frame{PC: pc}
+
+	lookup := pc
+	if call {
+		lookup--
+	}
+
+	fn, file, line := t.b.function(lookup)
+	if fn != nil {
+		f.Function, f.File, f.Line = fn.Name, file, line
+		f.Offset = pc - t.b.bias - fn.Entry
+	}
+
+	return f
+}
+
+// isSystem reports whether a goroutine started at fn belongs to the runtime,
+// after runtime.isSystemGoroutine.
+func isSystem(fn string) bool {
+	switch fn {
+	case "runtime.main", "runtime.handleAsyncEvent", "runtime.corostart":
+		return false
+	}
+	return strings.HasPrefix(fn, "runtime.")
+}
@@ -0,0 +1,203 @@
+package goroutine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"debug/elf"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jim-minter/gcore/pkg/core"
+	"github.com/jim-minter/gcore/pkg/gcore"
+)
+
+// buildTarget builds testdata/parked.go with the given -ldflags.  Test
+// binaries are linked without symbols or DWARF, so can't be the target
+// themselves.
+func buildTarget(t *testing.T, ldflags string) string {
+	exe := filepath.Join(t.TempDir(), "parked")
+
+	out, err := exec.Command("go", "build", "-ldflags="+ldflags, "-o", exe, "testdata/parked.go").CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	return exe
+}
+
+// startTarget builds and starts testdata/parked.go.
+func startTarget(t *testing.T, ldflags string) int {
+	cmd := exec.Command(buildTarget(t, ldflags))
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	_, err = bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The goroutines may not have blocked yet.
+	for i := 0; i < 100; i++ {
+		result, err := Dump(cmd.Process.Pid)
+		if errors.Is(err, syscall.EPERM) {
+			t.Skip(err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var blocked int
+		for _, g := range result.Goroutines {
+			if g.Status == "chan receive" {
+				blocked++
+			}
+		}
+		if blocked == 2 {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return cmd.Process.Pid
+}
+
+func checkResult(t *testing.T, result *Result) {
+	if !strings.HasPrefix(result.GoVersion, "go") {
+		t.Errorf("got Go version %q", result.GoVersion)
+	}
+
+	var found int
+	for _, g := range result.Goroutines {
+		if g.Err != nil {
+			t.Errorf("goroutine %d: %v", g.ID, g.Err)
+		}
+
+		for _, f := range g.Frames {
+			if f.Function != "main.parked" {
+				continue
+			}
+
+			found++
+
+			if g.Status != "chan receive" {
+				t.Errorf("goroutine %d: got status %q", g.ID, g.Status)
+			}
+			if !strings.HasSuffix(f.File, "parked.go") {
+				t.Errorf("goroutine %d: got file %q", g.ID, f.File)
+			}
+			if g.System {
+				t.Errorf("goroutine %d: unexpectedly a system goroutine", g.ID)
+			}
+			if g.CreatedBy == nil || g.CreatedBy.Function != "main.main" {
+				t.Errorf("goroutine %d: got created by %v", g.ID, g.CreatedBy)
+			}
+		}
+	}
+
+	if found != 2 {
+		t.Errorf("found %d parked goroutines, want 2", found)
+	}
+}
+
+// ldflags are the ways the target is linked: as usual, and without DWARF, so
+// that the runtime's struct layouts come from layouts.
+var ldflags = []string{"", "-w"}
+
+func TestDump(t *testing.T) {
+	for _, flags := range ldflags {
+		t.Run("ldflags="+flags, func(t *testing.T) {
+			pid := startTarget(t, flags)
+
+			result, err := Dump(pid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkResult(t, result)
+		})
+	}
+}
+
+func TestFromCore(t *testing.T) {
+	for _, flags := range ldflags {
+		t.Run("ldflags="+flags, func(t *testing.T) {
+			pid := startTarget(t, flags)
+
+			buf := &bytes.Buffer{}
+
+			_, err := gcore.Dump(context.Background(), pid, buf, gcore.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := core.NewFile(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := FromCore(f, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkResult(t, result)
+		})
+	}
+}
+
+// TestBuiltinLayout checks the built-in layout of the Go release building the
+// tests against the DWARF.
+func TestBuiltinLayout(t *testing.T) {
+	f, err := elf.Open(buildTarget(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	d, err := f.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := readLayout(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := builtinLayout(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *got != *want {
+		t.Errorf("got layout %+v, want %+v", *got, *want)
+	}
+}
@@ -0,0 +1,142 @@
+package goroutine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// pclntab magic numbers, from internal/abi/symtab.go.
+const (
+	go118PcLnTabMagic = 0xfffffff0
+	go120PcLnTabMagic = 0xfffffff1
+)
+
+// pclntab is the parts of a Go binary's .gopclntab section which unwinding
+// needs: the function table and each function's pcsp table.  Its layout is
+// described by runtime.pcHeader and runtime._func in the runtime's
+// symtab.go and runtime2.go.
+type pclntab struct {
+	data    []byte
+	text    uint64
+	quantum uint64
+	nfunc   int
+	functab []byte
+	pctab   []byte
+}
+
+// A funcInfo is a function in the pclntab, at link-time address entry.
+type funcInfo struct {
+	entry uint64
+	pcsp  uint32
+}
+
+// newPclntab parses data, the .gopclntab section of a binary whose text starts
+// at link-time address text.
+func newPclntab(data []byte, text uint64) (*pclntab, error) {
+	if len(data) < 72 {
+		return nil, errors.New("pclntab too short")
+	}
+
+	switch magic := binary.LittleEndian.Uint32(data); magic {
+	case go118PcLnTabMagic, go120PcLnTabMagic:
+	default:
+		return nil, fmt.Errorf("unsupported pclntab magic %#x (Go 1.18 or later is needed)", magic)
+	}
+
+	if data[7] != 8 {
+		return nil, fmt.Errorf("unsupported pclntab pointer size %d", data[7])
+	}
+
+	t := &pclntab{
+		data:    data,
+		text:    text,
+		quantum: uint64(data[6]),
+		nfunc:   int(binary.LittleEndian.Uint64(data[8:])),
+	}
+
+	pctabOffset := binary.LittleEndian.Uint64(data[56:])
+	pclnOffset := binary.LittleEndian.Uint64(data[64:])
+	if pctabOffset > uint64(len(data)) || pclnOffset+uint64(t.nfunc+1)*8 > uint64(len(data)) {
+		return nil, errors.New("pclntab offsets out of range")
+	}
+
+	t.pctab = data[pctabOffset:]
+	t.functab = data[pclnOffset:]
+
+	return t, nil
+}
+
+// entryOff returns the text offset of the i'th function's entry.  The entry
+// after the last function's is the end of the text.
+func (t *pclntab) entryOff(i int) uint64 {
+	return uint64(binary.LittleEndian.Uint32(t.functab[i*8:]))
+}
+
+// find returns the function containing link-time address pc, or nil if pc
+// isn't in Go code.
+func (t *pclntab) find(pc uint64) *funcInfo {
+	if pc < t.text || pc-t.text >= t.entryOff(t.nfunc) {
+		return nil
+	}
+	off := pc - t.text
+
+	i := sort.Search(t.nfunc, func(i int) bool { return t.entryOff(i+1) > off })
+	if i == t.nfunc {
+		return nil
+	}
+
+	funcoff := uint64(binary.LittleEndian.Uint32(t.functab[i*8+4:]))
+	if funcoff+20 > uint64(len(t.functab)) {
+		return nil
+	}
+	f := t.functab[funcoff:]
+
+	return &funcInfo{
+		entry: t.text + uint64(binary.LittleEndian.Uint32(f)),
+		pcsp:  binary.LittleEndian.Uint32(f[16:]),
+	}
+}
+
+// spdelta returns how far the stack pointer at link-time address pc in f is
+// below its value on entry to f, not counting the return address.
+func (t *pclntab) spdelta(f *funcInfo, pc uint64) (uint64, error) {
+	// Functions without a pcsp table, e.g. NOFRAME assembly, never move the
+	// stack pointer.
+	if f.pcsp == 0 {
+		return 0, nil
+	}
+	if uint64(f.pcsp) >= uint64(len(t.pctab)) {
+		return 0, fmt.Errorf("pcsp table of function at %#x out of range", f.entry)
+	}
+
+	p := t.pctab[f.pcsp:]
+	val, cur := int32(-1), f.entry
+
+	for first := true; ; first = false {
+		uvdelta, n := binary.Uvarint(p)
+		if n <= 0 || uvdelta == 0 && !first {
+			break
+		}
+		p = p[n:]
+
+		pcdelta, n := binary.Uvarint(p)
+		if n <= 0 {
+			break
+		}
+		p = p[n:]
+
+		val += int32(-(uvdelta & 1) ^ (uvdelta >> 1))
+		cur += pcdelta * t.quantum
+
+		if pc < cur {
+			if val < 0 {
+				return 0, fmt.Errorf("negative stack pointer delta at %#x", pc)
+			}
+			return uint64(val), nil
+		}
+	}
+
+	return 0, fmt.Errorf("no pcsp entry for %#x", pc)
+}
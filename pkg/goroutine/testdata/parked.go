@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func main() {
+	ch := make(chan struct{})
+	go parked(ch)
+	go parked(ch)
+
+	fmt.Println("ready")
+	time.Sleep(time.Hour)
+}
+
+//go:noinline
+func parked(ch chan struct{}) {
+	<-ch
+}
@@ -0,0 +1,98 @@
+package notes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/jim-minter/gcore/pkg/elf"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+type faultsHeader struct {
+	Count   uint64
+	Dropped uint64
+}
+
+type faultRecord struct {
+	Start uint64
+	End   uint64
+	Errno uint64
+}
+
+// FaultsSize returns the size of a faults note with room for capacity faults.
+func FaultsSize(capacity int) int {
+	return noteSize(GCORE, binary.Size(faultsHeader{})+capacity*binary.Size(faultRecord{}))
+}
+
+// Faults returns a note listing the memory ranges which couldn't be read.  Its
+// description always has room for exactly capacity faults, so that its size
+// can be fixed before the faults are known; any more are counted as dropped.
+func Faults(faults []proc.Fault, capacity int) (*elf.Note, error) {
+	buf := &bytes.Buffer{}
+
+	h := faultsHeader{Count: uint64(len(faults))}
+	if len(faults) > capacity {
+		h.Count, h.Dropped = uint64(capacity), uint64(len(faults)-capacity)
+		faults = faults[:capacity]
+	}
+
+	err := binary.Write(buf, binary.LittleEndian, h)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]faultRecord, capacity)
+	for i, f := range faults {
+		records[i] = faultRecord{Start: f.Start, End: f.End}
+
+		var errno syscall.Errno
+		if errors.As(f.Err, &errno) {
+			records[i].Errno = uint64(errno)
+		}
+	}
+
+	err = binary.Write(buf, binary.LittleEndian, records)
+	if err != nil {
+		return nil, err
+	}
+
+	return &elf.Note{
+		Name:        GCORE,
+		Description: buf.Bytes(),
+		Type:        NT_GCORE_FAULTS,
+	}, nil
+}
+
+// DecodeFaults decodes the description of an NT_GCORE_FAULTS note.
+func DecodeFaults(desc []byte) (faults []proc.Fault, dropped uint64, err error) {
+	r := bytes.NewReader(desc)
+
+	var h faultsHeader
+	err = binary.Read(r, binary.LittleEndian, &h)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if h.Count > uint64(r.Len()/binary.Size(faultRecord{})) {
+		return nil, 0, fmt.Errorf("invalid fault count %d", h.Count)
+	}
+
+	records := make([]faultRecord, h.Count)
+	err = binary.Read(r, binary.LittleEndian, records)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, rec := range records {
+		f := proc.Fault{Start: rec.Start, End: rec.End}
+		if rec.Errno != 0 {
+			f.Err = syscall.Errno(rec.Errno)
+		}
+		faults = append(faults, f)
+	}
+
+	return faults, h.Dropped, nil
+}
@@ -25,7 +25,7 @@ func File(pid int) (*elf.Note, error) {
 	paths := &bytes.Buffer{}
 
 	for _, smap := range smaps {
-		if smap.Pathname == "" || smap.Pathname[0] == '[' {
+		if !smap.IsFile() {
 			continue
 		}
 
@@ -16,7 +16,7 @@ import (
 func Fpregset(pid, tid int) (*elf.Note, error) {
 	fpregset := &C.struct_user_fpregs_struct{}
 
-	err := ptrace.Do(func() (err error) {
+	err := ptrace.Do(pid, func() (err error) {
 		_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, C.PTRACE_GETFPREGS, uintptr(tid), 0, uintptr(unsafe.Pointer(fpregset)), 0, 0)
 		if errno != 0 {
 			err = errno
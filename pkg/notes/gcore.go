@@ -0,0 +1,15 @@
+package notes
+
+// Notes with this name are specific to gcore.
+const GCORE = "GCORE"
+
+const (
+	NT_GCORE_FAULTS   = 1
+	NT_GCORE_REGIONS  = 2
+	NT_GCORE_METADATA = 3
+	NT_GCORE_MISSING  = 4
+)
+
+func noteSize(name string, descsz int) int {
+	return 12 + (len(name)+1+3)&^3 + (descsz+3)&^3
+}
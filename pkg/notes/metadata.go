@@ -0,0 +1,171 @@
+package notes
+
+import (
+	"bytes"
+	debugelf "debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jim-minter/gcore/pkg/elf"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+// Metadata describes where a process ran, for cores which are examined away
+// from the host or container it ran in.
+type Metadata struct {
+	// Pid and Tid are as seen by gcore; NSpid is the process's ID in each of
+	// its nested pid namespaces, outermost first.  Tid is the thread which
+	// received the fatal signal, if known.
+	Pid   int   `json:"pid"`
+	Tid   int   `json:"tid,omitempty"`
+	NSpid []int `json:"nspid,omitempty"`
+
+	ContainerID string            `json:"containerID,omitempty"`
+	Cgroups     []proc.Cgroup     `json:"cgroups,omitempty"`
+	Namespaces  map[string]uint64 `json:"namespaces,omitempty"`
+
+	Files []MappedFile `json:"files,omitempty"`
+}
+
+// A MappedFile is a file mapped by the process, as found through its root
+// directory, so in its container if it has one.
+type MappedFile struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size,omitempty"`
+	BuildID string `json:"buildID,omitempty"`
+	Err     string `json:"error,omitempty"`
+}
+
+// ReadMetadata gathers the metadata of process pid, whose thread tid, if not
+// zero, received a fatal signal.
+func ReadMetadata(pid, tid int) (*Metadata, error) {
+	m := &Metadata{Pid: pid, Tid: tid}
+
+	status, err := proc.ReadStatus(pid)
+	if err != nil {
+		return nil, err
+	}
+	m.NSpid = status.NSpid
+
+	m.Cgroups, err = proc.ReadCgroups(pid)
+	if err != nil {
+		return nil, err
+	}
+	m.ContainerID = proc.ContainerID(m.Cgroups)
+
+	m.Namespaces, err = proc.Namespaces(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	smaps, err := proc.ReadSmaps(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, smap := range smaps {
+		if !smap.IsFile() || seen[smap.Pathname] {
+			continue
+		}
+		seen[smap.Pathname] = true
+
+		m.Files = append(m.Files, readMappedFile(pid, smap.Pathname))
+	}
+
+	return m, nil
+}
+
+func readMappedFile(pid int, path string) MappedFile {
+	mf := MappedFile{Path: path}
+
+	f, err := os.Open(filepath.Join(fmt.Sprintf("/proc/%d/root", pid), path))
+	if err != nil {
+		mf.Err = err.Error()
+		return mf
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		mf.Err = err.Error()
+		return mf
+	}
+	mf.Size = fi.Size()
+
+	mf.BuildID = buildID(f)
+
+	return mf
+}
+
+const ntGNUBuildID = 3
+
+// buildID returns the hex GNU build ID of ELF file r, or "" if it has none.
+func buildID(r io.ReaderAt) string {
+	f, err := debugelf.NewFile(r)
+	if err != nil {
+		return ""
+	}
+
+	for _, prog := range f.Progs {
+		if prog.Type != debugelf.PT_NOTE {
+			continue
+		}
+
+		b := make([]byte, prog.Filesz)
+		_, err = prog.ReadAt(b, 0)
+		if err != nil {
+			continue
+		}
+
+		for len(b) >= 12 {
+			namesz := int(binary.LittleEndian.Uint32(b))
+			descsz := int(binary.LittleEndian.Uint32(b[4:]))
+			typ := binary.LittleEndian.Uint32(b[8:])
+			b = b[12:]
+
+			name, desc := (namesz+3)&^3, (descsz+3)&^3
+			if name+desc > len(b) {
+				break
+			}
+
+			if typ == ntGNUBuildID && string(bytes.TrimRight(b[:namesz], "\x00")) == "GNU" {
+				return hex.EncodeToString(b[name : name+descsz])
+			}
+			b = b[name+desc:]
+		}
+	}
+
+	return ""
+}
+
+// MetadataNote returns a note holding m, encoded as JSON.
+func MetadataNote(m *Metadata) (*elf.Note, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &elf.Note{
+		Name:        GCORE,
+		Description: b,
+		Type:        NT_GCORE_METADATA,
+	}, nil
+}
+
+// DecodeMetadata decodes the description of an NT_GCORE_METADATA note.
+func DecodeMetadata(desc []byte) (*Metadata, error) {
+	m := &Metadata{}
+
+	err := json.Unmarshal(desc, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,42 @@
+package notes
+
+import (
+	"encoding/json"
+
+	"github.com/jim-minter/gcore/pkg/elf"
+)
+
+// A Missing note is one which gcore couldn't write, or could only write in
+// part, e.g. because the process was frozen rather than seized.
+type Missing struct {
+	// Type is the note's type, e.g. NT_FPREGSET.
+	Type string `json:"type"`
+
+	// Tid is the thread it would have described, if it is per-thread.
+	Tid int `json:"tid,omitempty"`
+
+	// Partial is set if the note was written, but with fields missing.
+	Partial bool `json:"partial,omitempty"`
+
+	Err string `json:"error"`
+}
+
+// MissingNote returns a note listing missing, encoded as JSON.
+func MissingNote(missing []Missing) (*elf.Note, error) {
+	b, err := json.Marshal(missing)
+	if err != nil {
+		return nil, err
+	}
+
+	return &elf.Note{
+		Name:        GCORE,
+		Description: b,
+		Type:        NT_GCORE_MISSING,
+	}, nil
+}
+
+// DecodeMissing decodes the description of an NT_GCORE_MISSING note.
+func DecodeMissing(desc []byte) (missing []Missing, err error) {
+	err = json.Unmarshal(desc, &missing)
+	return missing, err
+}
@@ -6,15 +6,48 @@ package notes
 import "C"
 
 import (
-	"syscall"
 	"unsafe"
 
+	"golang.org/x/sys/unix"
+
 	elf "github.com/jim-minter/gcore/pkg/elf"
 	"github.com/jim-minter/gcore/pkg/proc"
 	"github.com/jim-minter/gcore/pkg/ptrace"
 )
 
-func Prstatus(pid, tid int) (*elf.Note, error) {
+// Prstatus returns thread tid's NT_PRSTATUS note.  sig is the signal the
+// thread is about to handle, if any, as reported for a crashing thread.
+func Prstatus(pid, tid int, sig unix.Signal) (*elf.Note, error) {
+	regs, err := ptrace.GetRegs(pid, tid)
+	if err != nil {
+		return nil, err
+	}
+
+	return prstatus(pid, tid, sig, regs, true)
+}
+
+// PartialPrstatus returns an NT_PRSTATUS note for thread tid, which isn't
+// seized, with only the registers found in /proc/<pid>/task/<tid>/syscall:
+// rsp, rip and, if the thread is in a system call, its number and arguments.
+func PartialPrstatus(pid, tid int) (*elf.Note, error) {
+	sc, err := proc.ReadSyscall(pid, tid)
+	if err != nil {
+		return nil, err
+	}
+
+	regs := &unix.PtraceRegs{
+		Rsp:      sc.SP,
+		Rip:      sc.PC,
+		Orig_rax: uint64(sc.Nr),
+	}
+	if sc.Nr >= 0 {
+		regs.Rdi, regs.Rsi, regs.Rdx, regs.R10, regs.R8, regs.R9 = sc.Args[0], sc.Args[1], sc.Args[2], sc.Args[3], sc.Args[4], sc.Args[5]
+	}
+
+	return prstatus(pid, tid, 0, regs, false)
+}
+
+func prstatus(pid, tid int, sig unix.Signal, regs *unix.PtraceRegs, fpvalid bool) (*elf.Note, error) {
 	stat, err := proc.ReadStat(pid, tid)
 	if err != nil {
 		return nil, err
@@ -31,16 +64,14 @@ func Prstatus(pid, tid int) (*elf.Note, error) {
 		pr_stime:   C.struct_timeval{tv_sec: C.long(stat.Stime) / 1000000, tv_usec: C.long(stat.Stime) % 1000000},
 		pr_cutime:  C.struct_timeval{tv_sec: C.long(stat.Cutime) / 1000000, tv_usec: C.long(stat.Cutime) % 1000000},
 		pr_cstime:  C.struct_timeval{tv_sec: C.long(stat.Cstime) / 1000000, tv_usec: C.long(stat.Cstime) % 1000000},
-		pr_fpvalid: 1,
+		pr_cursig:  C.short(sig),
+	}
+	prstatus.pr_info.si_signo = C.int(sig)
+	if fpvalid {
+		prstatus.pr_fpvalid = 1
 	}
 
-	err = ptrace.Do(func() (err error) {
-		_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, C.PTRACE_GETREGS, uintptr(tid), 0, uintptr(unsafe.Pointer(&prstatus.pr_reg)), 0, 0)
-		if errno != 0 {
-			err = errno
-		}
-		return err
-	})
+	*(*unix.PtraceRegs)(unsafe.Pointer(&prstatus.pr_reg)) = *regs
 
 	return &elf.Note{
 		Name:        "CORE",
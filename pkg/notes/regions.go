@@ -0,0 +1,136 @@
+package notes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/jim-minter/gcore/pkg/elf"
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+// A Region describes a mapping of the target and what was written of it.
+type Region struct {
+	Start     uint64
+	End       uint64
+	Filesz    uint64
+	Perms     proc.Perm
+	Reason    proc.DumpReason
+	Rss       uint64
+	Swap      uint64
+	Anonymous uint64
+	VmFlags   string
+	Pathname  string
+}
+
+type regionsHeader struct {
+	Count uint64
+}
+
+type regionRecord struct {
+	Start     uint64
+	End       uint64
+	Filesz    uint64
+	Rss       uint64
+	Swap      uint64
+	Anonymous uint64
+	Perms     uint32
+	Reason    uint32
+}
+
+// Regions returns a note listing every mapping of the target.  As with
+// NT_FILE, the fixed-size records are followed by the strings of each record in
+// turn, here its VmFlags and pathname, each NUL-terminated.
+func Regions(regions []Region) (*elf.Note, error) {
+	buf := &bytes.Buffer{}
+
+	err := binary.Write(buf, binary.LittleEndian, regionsHeader{Count: uint64(len(regions))})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range regions {
+		err = binary.Write(buf, binary.LittleEndian, regionRecord{
+			Start:     r.Start,
+			End:       r.End,
+			Filesz:    r.Filesz,
+			Rss:       r.Rss,
+			Swap:      r.Swap,
+			Anonymous: r.Anonymous,
+			Perms:     uint32(r.Perms),
+			Reason:    uint32(r.Reason),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, r := range regions {
+		fmt.Fprintf(buf, "%s\x00%s\x00", r.VmFlags, r.Pathname)
+	}
+
+	return &elf.Note{
+		Name:        GCORE,
+		Description: buf.Bytes(),
+		Type:        NT_GCORE_REGIONS,
+	}, nil
+}
+
+// DecodeRegions decodes the description of an NT_GCORE_REGIONS note.
+func DecodeRegions(desc []byte) ([]Region, error) {
+	r := bytes.NewReader(desc)
+
+	var h regionsHeader
+	err := binary.Read(r, binary.LittleEndian, &h)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Count > uint64(r.Len()/binary.Size(regionRecord{})) {
+		return nil, fmt.Errorf("invalid region count %d", h.Count)
+	}
+
+	records := make([]regionRecord, h.Count)
+	err = binary.Read(r, binary.LittleEndian, records)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(r)
+	str := func() (string, error) {
+		s, err := br.ReadString(0)
+		if err != nil {
+			return "", fmt.Errorf("truncated region strings: %v", err)
+		}
+		return s[:len(s)-1], nil
+	}
+
+	regions := make([]Region, 0, len(records))
+	for _, rec := range records {
+		region := Region{
+			Start:     rec.Start,
+			End:       rec.End,
+			Filesz:    rec.Filesz,
+			Perms:     proc.Perm(rec.Perms),
+			Reason:    proc.DumpReason(rec.Reason),
+			Rss:       rec.Rss,
+			Swap:      rec.Swap,
+			Anonymous: rec.Anonymous,
+		}
+
+		region.VmFlags, err = str()
+		if err != nil {
+			return nil, err
+		}
+
+		region.Pathname, err = str()
+		if err != nil {
+			return nil, err
+		}
+
+		regions = append(regions, region)
+	}
+
+	return regions, nil
+}
@@ -0,0 +1,64 @@
+package notes
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+func TestRegions(t *testing.T) {
+	want := []Region{
+		{
+			Start:    0x400000,
+			End:      0x402000,
+			Filesz:   0x1000,
+			Perms:    proc.PermR | proc.PermP,
+			Reason:   proc.ReasonELFHeader,
+			Rss:      0x2000,
+			VmFlags:  "rd mr mw me dw sd",
+			Pathname: "/usr/bin/sleep",
+		},
+		{
+			Start:     0x7ffc00000000,
+			End:       0x7ffc00021000,
+			Filesz:    0x21000,
+			Perms:     proc.PermR | proc.PermW | proc.PermP,
+			Reason:    proc.ReasonFilter,
+			Rss:       0x3000,
+			Swap:      0x1000,
+			Anonymous: 0x3000,
+			VmFlags:   "rd wr mr mw me gd ac",
+			Pathname:  "[stack]",
+		},
+		{
+			Start:  0x7ffc00100000,
+			End:    0x7ffc00101000,
+			Reason: proc.ReasonUnreadable,
+		},
+	}
+
+	n, err := Regions(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.Name != GCORE || n.Type != NT_GCORE_REGIONS {
+		t.Errorf("got note %s/%d", n.Name, n.Type)
+	}
+
+	got, err := DecodeRegions(n.Description)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, diff := range deep.Equal(got, want) {
+		t.Error(diff)
+	}
+
+	_, err = DecodeRegions(n.Description[:len(n.Description)-1])
+	if err == nil {
+		t.Error("expected error decoding truncated note")
+	}
+}
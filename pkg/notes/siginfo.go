@@ -9,18 +9,28 @@ import (
 	"syscall"
 	"unsafe"
 
+	"golang.org/x/sys/unix"
+
 	"github.com/jim-minter/gcore/pkg/elf"
 	"github.com/jim-minter/gcore/pkg/ptrace"
 )
 
-func Siginfo(pid, tid int) (*elf.Note, error) {
+// Siginfo returns thread tid's NT_SIGINFO note.  If sig is set, tid is in a
+// signal-delivery-stop for it and that signal's siginfo is recorded, as for a
+// crashing thread; otherwise the first pending signal's is.
+func Siginfo(pid, tid int, sig unix.Signal) (*elf.Note, error) {
 	siginfo := &C.siginfo_t{}
 	args := &C.struct___ptrace_peeksiginfo_args{
 		nr: 1,
 	}
 
-	err := ptrace.Do(func() (err error) {
-		_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, C.PTRACE_PEEKSIGINFO, uintptr(tid), uintptr(unsafe.Pointer(args)), uintptr(unsafe.Pointer(siginfo)), 0, 0)
+	err := ptrace.Do(pid, func() (err error) {
+		var errno syscall.Errno
+		if sig != 0 {
+			_, _, errno = syscall.Syscall6(syscall.SYS_PTRACE, C.PTRACE_GETSIGINFO, uintptr(tid), 0, uintptr(unsafe.Pointer(siginfo)), 0, 0)
+		} else {
+			_, _, errno = syscall.Syscall6(syscall.SYS_PTRACE, C.PTRACE_PEEKSIGINFO, uintptr(tid), uintptr(unsafe.Pointer(args)), uintptr(unsafe.Pointer(siginfo)), 0, 0)
+		}
 		if errno != 0 {
 			err = errno
 		}
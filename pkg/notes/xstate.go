@@ -20,7 +20,7 @@ func Xstate(pid, tid int) (*elf.Note, error) {
 	var xstate [X86_XSTATE_MAX_SIZE]byte
 	iov := unix.Iovec{Base: &xstate[0], Len: X86_XSTATE_MAX_SIZE}
 
-	err := ptrace.Do(func() (err error) {
+	err := ptrace.Do(pid, func() (err error) {
 		_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, C.PTRACE_GETREGSET, uintptr(tid), C.NT_X86_XSTATE, uintptr(unsafe.Pointer(&iov)), 0, 0)
 		if errno != 0 {
 			err = errno
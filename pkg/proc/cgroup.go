@@ -0,0 +1,74 @@
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Cgroup is a line of /proc/<pid>/cgroup.  On the unified (v2) hierarchy,
+// ID is 0 and Controllers is empty.
+type Cgroup struct {
+	ID          int      `json:"id"`
+	Controllers []string `json:"controllers,omitempty"`
+	Path        string   `json:"path"`
+}
+
+func ReadCgroups(pid int) ([]Cgroup, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	return readCgroups(b)
+}
+
+func readCgroups(b []byte) (cgroups []Cgroup, err error) {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		fields := strings.SplitN(s.Text(), ":", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid cgroup line %q", s.Text())
+		}
+
+		cg := Cgroup{Path: fields[2]}
+
+		cg.ID, err = strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if fields[1] != "" {
+			cg.Controllers = strings.Split(fields[1], ",")
+		}
+
+		cgroups = append(cgroups, cg)
+	}
+
+	return cgroups, s.Err()
+}
+
+// containerRx matches the cgroup path components that container runtimes name
+// after container IDs, e.g. <id>, docker-<id>.scope or
+// cri-containerd-<id>.scope.
+var containerRx = regexp.MustCompile(`^(?:.*-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+// ContainerID returns the ID of the container which cgroups places a process
+// in, or "" if none is recognised.  With nested containers, it is the
+// innermost.
+func ContainerID(cgroups []Cgroup) string {
+	for _, cg := range cgroups {
+		parts := strings.Split(cg.Path, "/")
+		for i := len(parts) - 1; i >= 0; i-- {
+			if m := containerRx.FindStringSubmatch(parts[i]); m != nil {
+				return m[1]
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,57 @@
+package proc
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestReadCgroups(t *testing.T) {
+	const path = "/kubepods/burstable/pod5d1e3d57-8e1e-4b1c-9b0a-5e8f3c9d0a11/3f4e2b8c9d1a7e6f5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a"
+
+	want := []Cgroup{
+		{ID: 12, Controllers: []string{"pids"}, Path: path},
+		{ID: 11, Controllers: []string{"memory"}, Path: path},
+		{ID: 1, Controllers: []string{"name=systemd"}, Path: path},
+		{ID: 0, Path: "/"},
+	}
+
+	b, err := ioutil.ReadFile("testdata/cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readCgroups(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, diff := range deep.Equal(got, want) {
+		t.Error(diff)
+	}
+
+	if id := ContainerID(got); id != "3f4e2b8c9d1a7e6f5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a" {
+		t.Errorf("got container ID %q", id)
+	}
+}
+
+func TestContainerID(t *testing.T) {
+	const id = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{path: "/docker/" + id, want: id},
+		{path: "/system.slice/docker-" + id + ".scope", want: id},
+		{path: "/kubepods.slice/kubepods-pod1.slice/cri-containerd-" + id + ".scope", want: id},
+		{path: "/machine.slice/libpod-" + id + ".scope/container", want: id},
+		{path: "/user.slice/user-1000.slice/session-2.scope", want: ""},
+		{path: "/", want: ""},
+	} {
+		if got := ContainerID([]Cgroup{{Path: tt.path}}); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CoredumpFilter is the bitmask described in core(5) which selects the
+// mappings whose contents are written to a core file.
+type CoredumpFilter uint32
+
+const (
+	FilterAnonPrivate CoredumpFilter = 1 << iota
+	FilterAnonShared
+	FilterMappedPrivate
+	FilterMappedShared
+	FilterELFHeaders
+	FilterHugetlbPrivate
+	FilterHugetlbShared
+	FilterDAXPrivate
+	FilterDAXShared
+)
+
+const DefaultCoredumpFilter = FilterAnonPrivate | FilterAnonShared | FilterELFHeaders | FilterHugetlbPrivate
+
+func ReadCoredumpFilter(pid int) (CoredumpFilter, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/coredump_filter", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	var f CoredumpFilter
+	err = f.Set(string(b))
+	return f, err
+}
+
+func (f CoredumpFilter) String() string {
+	return fmt.Sprintf("%08x", uint32(f))
+}
+
+func (f *CoredumpFilter) Set(s string) error {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return err
+	}
+
+	*f = CoredumpFilter(v)
+	return nil
+}
+
+// DumpReason records why a mapping's contents were or weren't written to a
+// core file.
+type DumpReason uint32
+
+const (
+	ReasonAlways     DumpReason = iota + 1 // [vdso] and [vsyscall] are always written
+	ReasonFilter                           // written, as selected by the filter
+	ReasonELFHeader                        // only the ELF header page was written
+	ReasonFiltered                         // excluded by the filter
+	ReasonUntouched                        // private anonymous, never written to
+	ReasonDontDump                         // excluded by MADV_DONTDUMP
+	ReasonIO                               // VM_IO or VM_PFNMAP, e.g. [vvar]
+	ReasonUnreadable                       // not readable, e.g. PROT_NONE
+)
+
+var reasons = map[DumpReason]string{
+	ReasonAlways:     "always",
+	ReasonFilter:     "filter",
+	ReasonELFHeader:  "elf-header",
+	ReasonFiltered:   "filtered",
+	ReasonUntouched:  "untouched",
+	ReasonDontDump:   "dontdump",
+	ReasonIO:         "io",
+	ReasonUnreadable: "unreadable",
+}
+
+func (r DumpReason) String() string {
+	if s, ok := reasons[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown(%d)", uint32(r))
+}
+
+// DumpSize returns the number of bytes from the start of smap which the kernel
+// would write to a core file, following vma_dump_size() in
+// fs/binfmt_elf.c.  isELF is only called when the kernel would check the
+// mapping for an ELF header.  DAX mappings can't be identified from smaps and
+// are treated as ordinary file mappings.
+func (f CoredumpFilter) DumpSize(smap *Smap, isELF func(*Smap) bool) uint64 {
+	size, _ := f.Classify(smap, isELF)
+	return size
+}
+
+// Classify is DumpSize, but also returns the reason for the size.
+func (f CoredumpFilter) Classify(smap *Smap, isELF func(*Smap) bool) (uint64, DumpReason) {
+	size := smap.End - smap.Start
+
+	whole := func(bit CoredumpFilter) (uint64, DumpReason) {
+		if f&bit != 0 {
+			return size, ReasonFilter
+		}
+		return 0, ReasonFiltered
+	}
+
+	switch {
+	case smap.Pathname == "[vdso]" || smap.Pathname == "[vsyscall]":
+		return size, ReasonAlways
+
+	case smap.HasVMFlag("dd"):
+		return 0, ReasonDontDump
+
+	case smap.HasVMFlag("ht"):
+		if smap.Perms&PermS != 0 {
+			return whole(FilterHugetlbShared)
+		}
+		return whole(FilterHugetlbPrivate)
+
+	case smap.HasVMFlag("io"):
+		return 0, ReasonIO
+
+	case smap.Perms&PermS != 0:
+		if strings.HasSuffix(smap.Pathname, " (deleted)") {
+			return whole(FilterAnonShared)
+		}
+		return whole(FilterMappedShared)
+
+	case f&FilterAnonPrivate != 0 && smap.hasAnonPages():
+		return size, ReasonFilter
+
+	case !smap.IsFile():
+		if smap.hasAnonPages() {
+			return 0, ReasonFiltered
+		}
+		return 0, ReasonUntouched
+
+	case f&FilterMappedPrivate != 0:
+		return size, ReasonFilter
+
+	case f&FilterELFHeaders != 0 && smap.Offset == 0 && smap.Perms&PermR != 0 && isELF(smap):
+		return uint64(os.Getpagesize()), ReasonELFHeader
+	}
+
+	return 0, ReasonFiltered
+}
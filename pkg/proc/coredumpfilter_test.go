@@ -0,0 +1,141 @@
+package proc
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDumpSize(t *testing.T) {
+	pagesize := uint64(os.Getpagesize())
+
+	anon := map[string]string{"anonymous": "8 kb", "swap": "0 kb"}
+	clean := map[string]string{"anonymous": "0 kb", "swap": "0 kb"}
+
+	for _, tt := range []struct {
+		name   string
+		smap   *Smap
+		filter CoredumpFilter
+		isELF  bool
+		want   uint64
+		reason DumpReason
+	}{
+		{
+			name:   "vdso is always dumped",
+			smap:   &Smap{Perms: PermR | PermX | PermP, Pathname: "[vdso]", Data: map[string]string{"vmflags": "rd ex dd"}},
+			filter: 0,
+			want:   0x10000,
+			reason: ReasonAlways,
+		},
+		{
+			name:   "dontdump",
+			smap:   &Smap{Perms: PermR | PermW | PermP, Data: map[string]string{"vmflags": "rd wr dd", "anonymous": "8 kb"}},
+			filter: DefaultCoredumpFilter,
+			reason: ReasonDontDump,
+		},
+		{
+			name:   "io",
+			smap:   &Smap{Perms: PermR | PermP, Pathname: "[vvar]", Data: map[string]string{"vmflags": "rd io"}},
+			filter: ^CoredumpFilter(0),
+			reason: ReasonIO,
+		},
+		{
+			name:   "hugetlb private",
+			smap:   &Smap{Perms: PermR | PermW | PermP, Data: map[string]string{"vmflags": "rd wr ht"}},
+			filter: FilterHugetlbPrivate,
+			want:   0x10000,
+			reason: ReasonFilter,
+		},
+		{
+			name:   "hugetlb shared excluded",
+			smap:   &Smap{Perms: PermR | PermW | PermS, Data: map[string]string{"vmflags": "rd wr sh ht"}},
+			filter: DefaultCoredumpFilter,
+			reason: ReasonFiltered,
+		},
+		{
+			name:   "anonymous shared",
+			smap:   &Smap{Perms: PermR | PermW | PermS, Pathname: "/dev/zero (deleted)", Data: clean},
+			filter: FilterAnonShared,
+			want:   0x10000,
+			reason: ReasonFilter,
+		},
+		{
+			name:   "mapped shared excluded",
+			smap:   &Smap{Perms: PermR | PermS, Pathname: "/usr/lib64/gconv/gconv-modules.cache", Data: clean},
+			filter: DefaultCoredumpFilter,
+			reason: ReasonFiltered,
+		},
+		{
+			name:   "mapped shared",
+			smap:   &Smap{Perms: PermR | PermS, Pathname: "/usr/lib64/gconv/gconv-modules.cache", Data: clean},
+			filter: FilterMappedShared,
+			want:   0x10000,
+			reason: ReasonFilter,
+		},
+		{
+			name:   "written private file mapping",
+			smap:   &Smap{Perms: PermR | PermW | PermP, Pathname: "/usr/bin/cat", Data: anon},
+			filter: FilterAnonPrivate,
+			want:   0x10000,
+			reason: ReasonFilter,
+		},
+		{
+			name:   "untouched anonymous",
+			smap:   &Smap{Perms: PermR | PermW | PermP, Data: clean},
+			filter: ^CoredumpFilter(0),
+			reason: ReasonUntouched,
+		},
+		{
+			name:   "mapped private",
+			smap:   &Smap{Perms: PermR | PermX | PermP, Offset: 0x2000, Pathname: "/usr/bin/cat", Data: clean},
+			filter: FilterMappedPrivate,
+			want:   0x10000,
+			reason: ReasonFilter,
+		},
+		{
+			name:   "elf header",
+			smap:   &Smap{Perms: PermR | PermP, Pathname: "/usr/bin/cat", Data: clean},
+			filter: DefaultCoredumpFilter,
+			isELF:  true,
+			want:   pagesize,
+			reason: ReasonELFHeader,
+		},
+		{
+			name:   "not an elf header",
+			smap:   &Smap{Perms: PermR | PermP, Pathname: "/usr/lib/locale/en_GB.utf8/LC_CTYPE", Data: clean},
+			filter: DefaultCoredumpFilter,
+			reason: ReasonFiltered,
+		},
+		{
+			name:   "elf header at non-zero offset",
+			smap:   &Smap{Perms: PermR | PermX | PermP, Offset: 0x2000, Pathname: "/usr/bin/cat", Data: clean},
+			filter: DefaultCoredumpFilter,
+			isELF:  true,
+			reason: ReasonFiltered,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.smap.End = tt.smap.Start + 0x10000
+
+			got, reason := tt.filter.Classify(tt.smap, func(*Smap) bool { return tt.isELF })
+			if got != tt.want {
+				t.Errorf("got %#x, want %#x", got, tt.want)
+			}
+			if reason != tt.reason {
+				t.Errorf("got reason %s, want %s", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCoredumpFilterSet(t *testing.T) {
+	var f CoredumpFilter
+
+	err := f.Set("00000033\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f != DefaultCoredumpFilter {
+		t.Errorf("got %s, want %s", f, DefaultCoredumpFilter)
+	}
+}
@@ -0,0 +1,124 @@
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Freeze freezes the processes in cgroup v2 directory dir, and its
+// descendants, by writing to its cgroup.freeze file, and waits until
+// cgroup.events reports them all frozen.  The returned function thaws them
+// again, unless the cgroup was already frozen, in which case it is left so.
+func Freeze(ctx context.Context, dir string) (thaw func() error, err error) {
+	freeze := filepath.Join(dir, "cgroup.freeze")
+
+	b, err := ioutil.ReadFile(freeze)
+	if os.IsNotExist(err) {
+		// Only the root cgroup has no cgroup.freeze.
+		if _, serr := os.Stat(filepath.Join(dir, "cgroup.procs")); serr == nil {
+			return nil, fmt.Errorf("%s is the root cgroup, which can't be frozen", dir)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(b)) == "1" {
+		return func() error { return nil }, nil
+	}
+
+	// Freezing ourselves would never end.
+	pids, err := cgroupProcs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, pid := range pids {
+		if pid == os.Getpid() {
+			return nil, fmt.Errorf("%s contains gcore itself", dir)
+		}
+	}
+
+	thaw = func() error {
+		return ioutil.WriteFile(freeze, []byte("0"), 0)
+	}
+
+	err = ioutil.WriteFile(freeze, []byte("1"), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		frozen, err := isFrozen(dir)
+		if err != nil {
+			thaw()
+			return nil, err
+		}
+		if frozen {
+			return thaw, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			thaw()
+			return nil, ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// isFrozen reads the frozen field of cgroup.events in cgroup directory dir.
+func isFrozen(dir string) (bool, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.events"))
+	if err != nil {
+		return false, err
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		if s.Text() == "frozen 1" {
+			return true, nil
+		}
+	}
+
+	return false, s.Err()
+}
+
+// CgroupDir returns the cgroup v2 directory of process pid, under the unified
+// hierarchy's usual mount point in root: /sys/fs/cgroup, or
+// /sys/fs/cgroup/unified on hosts which also mount v1 hierarchies.
+func CgroupDir(pid int, root string) (string, error) {
+	cgroups, err := ReadCgroups(pid)
+	if err != nil {
+		return "", err
+	}
+
+	mnt := CgroupMount(root)
+
+	for _, cg := range cgroups {
+		if cg.ID == 0 && len(cg.Controllers) == 0 {
+			if cg.Path == "/" {
+				return "", fmt.Errorf("process %d is in the root cgroup, which can't be frozen", pid)
+			}
+			return filepath.Join(mnt, cg.Path), nil
+		}
+	}
+
+	return "", fmt.Errorf("process %d is not in a cgroup v2 hierarchy", pid)
+}
+
+// CgroupMount returns the usual mount point in root of the unified cgroup v2
+// hierarchy.
+func CgroupMount(root string) string {
+	mnt := filepath.Join(root, "sys/fs/cgroup")
+	if _, err := os.Stat(filepath.Join(mnt, "unified/cgroup.controllers")); err == nil {
+		mnt = filepath.Join(mnt, "unified")
+	}
+
+	return mnt
+}
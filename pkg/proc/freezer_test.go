@@ -0,0 +1,21 @@
+package proc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFreezeRoot(t *testing.T) {
+	root := CgroupMount("/")
+	if _, err := os.Stat(filepath.Join(root, "cgroup.procs")); err != nil {
+		t.Skip(err)
+	}
+
+	_, err := Freeze(context.Background(), root)
+	if err == nil || !strings.Contains(err.Error(), "is the root cgroup") {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -1,10 +1,213 @@
 package proc
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 func Mem(pid int) (*os.File, error) {
 	return os.Open(fmt.Sprintf("/proc/%d/mem", pid))
 }
+
+// A Fault is a range of memory which couldn't be read.
+type Fault struct {
+	Start uint64
+	End   uint64
+	Err   error
+}
+
+// TolerantReader reads memory from r, typically /proc/<pid>/mem.  When a read
+// fails, it is retried a page at a time: pages which still can't be read are
+// returned as zeros and recorded as Faults, rather than failing the read.
+type TolerantReader struct {
+	r io.ReaderAt
+
+	excluded []Fault
+
+	mu     sync.Mutex
+	faults []Fault
+}
+
+func NewTolerantReader(r io.ReaderAt) *TolerantReader {
+	return &TolerantReader{r: r}
+}
+
+// Exclude has reads of [start, end) fail with err, so that the range is
+// returned as zeros and recorded as Faults whatever r would have read.  It must
+// be called before ReadAt.
+func (r *TolerantReader) Exclude(start, end uint64, err error) {
+	r.excluded = append(r.excluded, Fault{Start: start, End: end, Err: err})
+}
+
+func (r *TolerantReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.readAt(p, off)
+	if err == nil {
+		return n, nil
+	}
+
+	pagesize := os.Getpagesize()
+
+	for n < len(p) {
+		end := len(p)
+		if m := pagesize - int((uint64(off)+uint64(n))%uint64(pagesize)); n+m < end {
+			end = n + m
+		}
+
+		m, err := r.readAt(p[n:end], off+int64(n))
+		if err != nil {
+			for i := n + m; i < end; i++ {
+				p[i] = 0
+			}
+			r.fault(uint64(off)+uint64(n+m), uint64(off)+uint64(end), err)
+		}
+
+		n = end
+	}
+
+	return n, nil
+}
+
+func (r *TolerantReader) readAt(p []byte, off int64) (int, error) {
+	// Addresses in the upper half, e.g. [vsyscall], don't fit in an offset.
+	if off < 0 {
+		return 0, syscall.EFAULT
+	}
+
+	for _, e := range r.excluded {
+		if uint64(off) < e.End && uint64(off)+uint64(len(p)) > e.Start {
+			return 0, e.Err
+		}
+	}
+
+	return r.r.ReadAt(p, off)
+}
+
+func (r *TolerantReader) fault(start, end uint64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.faults) > 0 {
+		last := &r.faults[len(r.faults)-1]
+		if last.End == start && last.Err.Error() == err.Error() {
+			last.End = end
+			return
+		}
+	}
+
+	r.faults = append(r.faults, Fault{Start: start, End: end, Err: err})
+}
+
+// Faults returns the ranges which couldn't be read so far, in address order.
+// Adjacent ranges which failed the same way are merged, so that the result
+// doesn't depend on the order of concurrent reads.
+func (r *TolerantReader) Faults() []Fault {
+	r.mu.Lock()
+	faults := append([]Fault(nil), r.faults...)
+	r.mu.Unlock()
+
+	sort.Slice(faults, func(i, j int) bool { return faults[i].Start < faults[j].Start })
+
+	var merged []Fault
+	for _, f := range faults {
+		if n := len(merged); n > 0 && merged[n-1].End == f.Start && merged[n-1].Err.Error() == f.Err.Error() {
+			merged[n-1].End = f.End
+			continue
+		}
+		merged = append(merged, f)
+	}
+
+	return merged
+}
+
+// MemReader reads the memory of process pid.  It uses process_vm_readv, which
+// avoids a copy through the kernel's /proc/<pid>/mem page-at-a-time loop, and
+// reads whatever that can't through /proc/<pid>/mem, so that faults are
+// reported as they would be by the latter alone.  If neither is permitted, e.g.
+// by a seccomp policy, it falls back to Peek, if set.  It may be used
+// concurrently.
+type MemReader struct {
+	pid int
+	mem *os.File
+
+	// Peek reads the memory at addr into b, typically with PTRACE_PEEKDATA.
+	Peek func(b []byte, addr uintptr) (int, error)
+
+	noVM atomic.Bool
+}
+
+func NewMemReader(pid int) (*MemReader, error) {
+	r := &MemReader{pid: pid}
+
+	mem, err := Mem(pid)
+	switch {
+	case err == nil:
+		r.mem = mem
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+	default:
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *MemReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if !r.noVM.Load() {
+		n, err = r.readv(p, off)
+		if err == syscall.ENOSYS || err == syscall.EPERM {
+			r.noVM.Store(true)
+		}
+		if n == len(p) {
+			return n, nil
+		}
+	}
+
+	if r.mem != nil {
+		m, err := r.mem.ReadAt(p[n:], off+int64(n))
+		return n + m, err
+	}
+
+	if r.Peek != nil {
+		m, err := r.Peek(p[n:], uintptr(off)+uintptr(n))
+		return n + m, err
+	}
+
+	if err == nil {
+		err = syscall.EIO
+	}
+	return n, err
+}
+
+func (r *MemReader) readv(p []byte, off int64) (int, error) {
+	local := []unix.Iovec{{Base: &p[0]}}
+	local[0].SetLen(len(p))
+
+	remote := []unix.RemoteIovec{{Base: uintptr(off), Len: len(p)}}
+
+	for {
+		n, err := unix.ProcessVMReadv(r.pid, local, remote, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *MemReader) Close() error {
+	if r.mem == nil {
+		return nil
+	}
+
+	return r.mem.Close()
+}
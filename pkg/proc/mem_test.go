@@ -0,0 +1,184 @@
+package proc
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// holeyReader fails to read the pages listed in bad.
+type holeyReader struct {
+	b   []byte
+	bad map[int64]bool
+}
+
+func (r *holeyReader) ReadAt(p []byte, off int64) (int, error) {
+	pagesize := int64(os.Getpagesize())
+
+	for n := 0; n < len(p); {
+		if r.bad[(off+int64(n))/pagesize] {
+			return n, errors.New("input/output error")
+		}
+
+		m := copy(p[n:], r.b[off+int64(n):(off+int64(n))/pagesize*pagesize+pagesize])
+		n += m
+	}
+
+	return len(p), nil
+}
+
+func TestTolerantReader(t *testing.T) {
+	pagesize := os.Getpagesize()
+
+	b := bytes.Repeat([]byte{1}, 8*pagesize)
+	r := NewTolerantReader(&holeyReader{b: b, bad: map[int64]bool{2: true, 3: true, 6: true}})
+
+	p := make([]byte, 7*pagesize)
+	for i := range p {
+		p[i] = 0xff
+	}
+
+	n, err := r.ReadAt(p, int64(pagesize/2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(p) {
+		t.Fatalf("got %d bytes, want %d", n, len(p))
+	}
+
+	want := bytes.Repeat([]byte{1}, 7*pagesize)
+	for _, page := range []int{2, 3, 6} {
+		start := max(page*pagesize-pagesize/2, 0)
+		end := min(page*pagesize+pagesize/2, len(want))
+		for i := start; i < end; i++ {
+			want[i] = 0
+		}
+	}
+	if !bytes.Equal(p, want) {
+		t.Error("unexpected data")
+	}
+
+	var got [][2]uint64
+	for _, f := range r.Faults() {
+		got = append(got, [2]uint64{f.Start, f.End})
+	}
+
+	wantFaults := [][2]uint64{
+		{uint64(2 * pagesize), uint64(4 * pagesize)},
+		{uint64(6 * pagesize), uint64(7 * pagesize)},
+	}
+	if !reflect.DeepEqual(got, wantFaults) {
+		t.Errorf("got faults %x, want %x", got, wantFaults)
+	}
+}
+
+func TestTolerantReaderExclude(t *testing.T) {
+	pagesize := os.Getpagesize()
+
+	b := bytes.Repeat([]byte{1}, 4*pagesize)
+	r := NewTolerantReader(bytes.NewReader(b))
+
+	wiped := errors.New("wiped")
+	r.Exclude(uint64(pagesize), uint64(3*pagesize), wiped)
+
+	p := make([]byte, len(b))
+	_, err := r.ReadAt(p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := append(bytes.Repeat([]byte{1}, pagesize), make([]byte, 2*pagesize)...)
+	want = append(want, bytes.Repeat([]byte{1}, pagesize)...)
+	if !bytes.Equal(p, want) {
+		t.Error("unexpected data")
+	}
+
+	wantFaults := []Fault{{Start: uint64(pagesize), End: uint64(3 * pagesize), Err: wiped}}
+	if got := r.Faults(); !reflect.DeepEqual(got, wantFaults) {
+		t.Errorf("got faults %+v, want %+v", got, wantFaults)
+	}
+}
+
+func TestTolerantReaderFaultsOrder(t *testing.T) {
+	r := NewTolerantReader(nil)
+
+	eio := errors.New("input/output error")
+	r.fault(0x3000, 0x4000, eio)
+	r.fault(0x1000, 0x2000, eio)
+	r.fault(0x2000, 0x3000, eio)
+	r.fault(0x5000, 0x6000, eio)
+
+	var got [][2]uint64
+	for _, f := range r.Faults() {
+		got = append(got, [2]uint64{f.Start, f.End})
+	}
+
+	want := [][2]uint64{{0x1000, 0x4000}, {0x5000, 0x6000}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got faults %x, want %x", got, want)
+	}
+}
+
+func TestMemReader(t *testing.T) {
+	pagesize := os.Getpagesize()
+
+	b, err := unix.Mmap(-1, 0, 3*pagesize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(b)
+
+	for i := range b {
+		b[i] = byte(i)
+	}
+	addr := int64(uintptr(unsafe.Pointer(&b[0])))
+
+	r, err := NewMemReader(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	p := make([]byte, 2*pagesize)
+	n, err := r.ReadAt(p, addr+int64(pagesize/2))
+	if err != nil || n != len(p) {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if !bytes.Equal(p, b[pagesize/2:pagesize/2+len(p)]) {
+		t.Error("unexpected data")
+	}
+
+	// A read stopping at a hole fails as reading /proc/<pid>/mem alone would.
+	err = unix.Mprotect(b[2*pagesize:], unix.PROT_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Mprotect(b[2*pagesize:], unix.PROT_READ|unix.PROT_WRITE)
+
+	p = make([]byte, 3*pagesize)
+	n, wantErr := r.mem.ReadAt(p, addr)
+	m, err := r.ReadAt(p, addr)
+	if m != n || !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("got %d, %v, want %d, %v", m, err, n, wantErr)
+	}
+
+	// Without process_vm_readv or /proc/<pid>/mem, Peek is used.
+	r = &MemReader{
+		pid: os.Getpid(),
+		Peek: func(p []byte, addr uintptr) (int, error) {
+			return copy(p, b[addr-uintptr(unsafe.Pointer(&b[0])):]), nil
+		},
+	}
+	r.noVM.Store(true)
+
+	p = make([]byte, 16)
+	n, err = r.ReadAt(p, addr+1)
+	if err != nil || n != len(p) || !bytes.Equal(p, b[1:17]) {
+		t.Errorf("got %d, %v, %x", n, err, p)
+	}
+}
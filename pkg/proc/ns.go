@@ -0,0 +1,30 @@
+package proc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Namespaces returns the inode number of each of process pid's namespaces,
+// keyed by name, e.g. "mnt".  Two processes share a namespace if they have the
+// same inode.
+func Namespaces(pid int) (map[string]uint64, error) {
+	matches, err := filepath.Glob(fmt.Sprintf("/proc/%d/ns/*", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	nss := map[string]uint64{}
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			return nil, err
+		}
+
+		nss[filepath.Base(m)] = fi.Sys().(*syscall.Stat_t).Ino
+	}
+
+	return nss, nil
+}
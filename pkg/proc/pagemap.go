@@ -0,0 +1,60 @@
+package proc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// PagemapEntry is a single entry from /proc/<pid>/pagemap, described in the
+// kernel's Documentation/admin-guide/mm/pagemap.rst.
+type PagemapEntry uint64
+
+const (
+	pagemapSoftDirty PagemapEntry = 1 << 55
+	pagemapSwapped   PagemapEntry = 1 << 62
+	pagemapPresent   PagemapEntry = 1 << 63
+)
+
+func (e PagemapEntry) Present() bool {
+	return e&pagemapPresent != 0
+}
+
+func (e PagemapEntry) Swapped() bool {
+	return e&pagemapSwapped != 0
+}
+
+func (e PagemapEntry) SoftDirty() bool {
+	return e&pagemapSoftDirty != 0
+}
+
+func Pagemap(pid int) (*os.File, error) {
+	return os.Open(fmt.Sprintf("/proc/%d/pagemap", pid))
+}
+
+// ReadPagemap returns the pagemap entries for the pages in [start, end).
+func ReadPagemap(r io.ReaderAt, start, end uint64) ([]PagemapEntry, error) {
+	pagesize := uint64(os.Getpagesize())
+
+	b := make([]byte, (end-start)/pagesize*8)
+
+	_, err := r.ReadAt(b, int64(start/pagesize*8))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]PagemapEntry, len(b)/8)
+	for i := range entries {
+		entries[i] = PagemapEntry(binary.LittleEndian.Uint64(b[i*8:]))
+	}
+
+	return entries, nil
+}
+
+// ClearSoftDirty clears the soft-dirty bits of process pid's pages, so that
+// pagemap shows which have been written to since.  See the kernel's
+// Documentation/admin-guide/mm/soft-dirty.rst.
+func ClearSoftDirty(pid int) error {
+	return os.WriteFile(fmt.Sprintf("/proc/%d/clear_refs", pid), []byte("4"), 0)
+}
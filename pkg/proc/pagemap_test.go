@@ -0,0 +1,47 @@
+package proc
+
+import (
+	"os"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestReadPagemap(t *testing.T) {
+	pagesize := os.Getpagesize()
+
+	b, err := unix.Mmap(-1, 0, 8*pagesize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(b)
+
+	touched := map[int]bool{1: true, 2: true, 5: true}
+	for page := range touched {
+		b[page*pagesize] = 1
+	}
+
+	f, err := Pagemap(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	start := uint64(uintptr(unsafe.Pointer(&b[0])))
+
+	entries, err := ReadPagemap(f, start, start+uint64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 8 {
+		t.Fatalf("got %d entries, want 8", len(entries))
+	}
+
+	for page, e := range entries {
+		if e.Present() != touched[page] {
+			t.Errorf("page %d: got present %v, want %v", page, e.Present(), touched[page])
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Pids returns the ID of every process, in ascending order.
+func Pids() ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, e := range entries {
+		if pid, err := strconv.Atoi(e.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	return pids, nil
+}
+
+// Descendants returns pid and the IDs of its children, their children and so
+// on.  Processes which exit meanwhile are ignored.
+func Descendants(pid int) ([]int, error) {
+	pids, err := Pids()
+	if err != nil {
+		return nil, err
+	}
+
+	children := map[int][]int{}
+	for _, p := range pids {
+		stat, err := ReadStat(p, 0)
+		if err != nil {
+			continue
+		}
+		children[int(stat.Ppid)] = append(children[int(stat.Ppid)], p)
+	}
+
+	found := []int{pid}
+	for i := 0; i < len(found); i++ {
+		found = append(found, children[found[i]]...)
+	}
+	sort.Ints(found)
+
+	return found, nil
+}
+
+// CgroupProcs returns the IDs of the processes in cgroup v2 path and its
+// descendant cgroups.  path is as in /proc/<pid>/cgroup, and may optionally
+// be prefixed by the hierarchy's mount point, /sys/fs/cgroup or
+// /sys/fs/cgroup/unified.
+func CgroupProcs(path string) ([]int, error) {
+	root := CgroupMount("/")
+
+	if path != root && !strings.HasPrefix(path, root+"/") {
+		path = filepath.Join(root, path)
+	}
+
+	return cgroupProcs(path)
+}
+
+func cgroupProcs(path string) ([]int, error) {
+	var pids []int
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != "cgroup.procs" {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for s.Scan() {
+			pid, err := strconv.Atoi(s.Text())
+			if err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+			pids = append(pids, pid)
+		}
+
+		return s.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Ints(pids)
+
+	return pids, nil
+}
+
+// NamespaceProcs returns the IDs of the processes in the pid namespace whose
+// nsfs file is path, e.g. /proc/<pid>/ns/pid.  Processes in namespaces nested
+// within it aren't included.
+func NamespaceProcs(path string) ([]int, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	ino := fi.Sys().(*syscall.Stat_t).Ino
+
+	pids, err := Pids()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []int
+	for _, pid := range pids {
+		fi, err := os.Stat(fmt.Sprintf("/proc/%d/ns/pid", pid))
+		if err != nil {
+			continue
+		}
+
+		if fi.Sys().(*syscall.Stat_t).Ino == ino {
+			found = append(found, pid)
+		}
+	}
+
+	return found, nil
+}
+
+// MatchProcs returns the IDs of the processes whose name, or, if full is set,
+// whose command line, matches rx, like pgrep(1) and pgrep -f.  Kernel threads
+// and the calling process are never included.
+func MatchProcs(rx *regexp.Regexp, full bool) ([]int, error) {
+	pids, err := Pids()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []int
+	for _, pid := range pids {
+		if pid == os.Getpid() {
+			continue
+		}
+
+		cmdline, err := ReadCmdline(pid)
+		if err != nil || len(cmdline) == 0 {
+			continue
+		}
+
+		// The last argument's terminating NUL reads as a space.
+		s := strings.TrimSuffix(string(cmdline), " ")
+		if !full {
+			stat, err := ReadStat(pid, 0)
+			if err != nil {
+				continue
+			}
+			s = stat.Comm
+		}
+
+		if rx.MatchString(s) {
+			found = append(found, pid)
+		}
+	}
+
+	return found, nil
+}
+
+// ContainerProcs returns the IDs of the processes in the containers whose IDs,
+// as found by ContainerID, start with id.
+func ContainerProcs(id string) ([]int, error) {
+	pids, err := Pids()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []int
+	for _, pid := range pids {
+		cgroups, err := ReadCgroups(pid)
+		if err != nil {
+			continue
+		}
+
+		if cid := ContainerID(cgroups); cid != "" && strings.HasPrefix(cid, id) {
+			found = append(found, pid)
+		}
+	}
+
+	return found, nil
+}
+
+// Topmost returns those of pids whose parents aren't also in pids, e.g. the
+// main process of each container found by ContainerProcs.
+func Topmost(pids []int) []int {
+	in := map[int]bool{}
+	for _, pid := range pids {
+		in[pid] = true
+	}
+
+	var found []int
+	for _, pid := range pids {
+		stat, err := ReadStat(pid, 0)
+		if err != nil {
+			continue
+		}
+
+		if !in[int(stat.Ppid)] {
+			found = append(found, pid)
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,186 @@
+package proc
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func TestDescendants(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 60 & sleep 60 & echo; wait")
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if pids, err := Descendants(cmd.Process.Pid); err == nil {
+			for _, pid := range pids {
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+		}
+		cmd.Wait()
+	})
+
+	_, err = stdout.Read(make([]byte, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pids []int
+	for i := 0; i < 100; i++ {
+		pids, err = Descendants(cmd.Process.Pid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(pids) == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(pids) != 3 || pids[0] != cmd.Process.Pid {
+		t.Fatalf("got %v", pids)
+	}
+
+	for _, pid := range pids[1:] {
+		stat, err := ReadStat(pid, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Ppid != int32(cmd.Process.Pid) {
+			t.Errorf("%d: got ppid %d", pid, stat.Ppid)
+		}
+	}
+}
+
+func TestCgroupProcs(t *testing.T) {
+	dir := t.TempDir()
+
+	for path, procs := range map[string]string{
+		"cgroup.procs":       "3\n1\n",
+		"a/cgroup.procs":     "",
+		"a/b/cgroup.procs":   "2\n",
+		"c/cgroup.procs":     "4\n",
+		"c/cgroup.threads":   "5\n",
+		"c/memory.max":       "max\n",
+		"d/e/f/cgroup.procs": "6\n",
+	} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(filepath.Join(dir, path), []byte(procs), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := cgroupProcs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, diff := range deep.Equal(got, []int{1, 2, 3, 4, 6}) {
+		t.Error(diff)
+	}
+
+	_, err = cgroupProcs(filepath.Join(dir, "missing"))
+	if err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestNamespaceProcs(t *testing.T) {
+	pids, err := NamespaceProcs("/proc/self/ns/pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, pid := range pids {
+		if pid == os.Getpid() {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("%d not in %v", os.Getpid(), pids)
+	}
+}
+
+func TestMatchProcs(t *testing.T) {
+	cmd := exec.Command("sleep", "60.5")
+
+	err := cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	// Wait for the exec.
+	for i := 0; i < 100; i++ {
+		if cmdline, err := ReadCmdline(cmd.Process.Pid); err == nil && strings.HasPrefix(string(cmdline), "sleep 60.5") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, tt := range []struct {
+		rx   string
+		full bool
+		want bool
+	}{
+		{rx: "^sleep$", want: true},
+		{rx: "lee", want: true},
+		{rx: "60\\.5", want: false},
+		{rx: "^sleep 60\\.5$", full: true, want: true},
+	} {
+		pids, err := MatchProcs(regexp.MustCompile(tt.rx), tt.full)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var found bool
+		for _, pid := range pids {
+			if pid == cmd.Process.Pid {
+				found = true
+			}
+			if pid == os.Getpid() {
+				t.Errorf("%q: matched ourselves", tt.rx)
+			}
+		}
+		if found != tt.want {
+			t.Errorf("%q, full %v: found %v", tt.rx, tt.full, found)
+		}
+	}
+}
+
+func TestTopmost(t *testing.T) {
+	pids, err := Descendants(os.Getppid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, diff := range deep.Equal(Topmost(pids), []int{os.Getppid()}) {
+		t.Error(diff)
+	}
+}
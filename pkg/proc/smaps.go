@@ -40,6 +40,27 @@ func (smap *Smap) HasVMFlag(flag string) bool {
 	return false
 }
 
+// Bytes returns a size field such as "rss", which smaps reports in kB, in
+// bytes.  It returns 0 if the field is missing or malformed.
+func (smap *Smap) Bytes(key string) uint64 {
+	v, err := strconv.ParseUint(strings.TrimSuffix(smap.Data[key], " kb"), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return v * 1024
+}
+
+func (smap *Smap) IsFile() bool {
+	return smap.Pathname != "" && smap.Pathname[0] != '['
+}
+
+// hasAnonPages approximates the kernel's vma->anon_vma check: a private
+// mapping gets an anon_vma when it first has a page written to it.
+func (smap *Smap) hasAnonPages() bool {
+	return smap.Data["anonymous"] != "0 kb" || smap.Data["swap"] != "0 kb"
+}
+
 type Perm int
 
 const (
@@ -50,7 +71,7 @@ const (
 	PermP
 )
 
-var header = regexp.MustCompile(`^([0-9a-f]{0,16})-([0-9a-f]{0,16}) ([-r][-w][-x][-sp]) ([0-9a-f]{8}) ([0-9a-f]{2}:[0-9a-f]{2}) ([0-9]+) +([^ ]+)?`)
+var header = regexp.MustCompile(`^([0-9a-f]{0,16})-([0-9a-f]{0,16}) ([-r][-w][-x][-sp]) ([0-9a-f]{8}) ([0-9a-f]{2}:[0-9a-f]{2}) ([0-9]+) +(.*)$`)
 
 func ReadSmaps(pid int) ([]*Smap, error) {
 	f, err := os.Open(fmt.Sprintf("/proc/%d/smaps", pid))
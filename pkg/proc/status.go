@@ -0,0 +1,87 @@
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+type Status struct {
+	Name      string
+	State     byte
+	Tgid      int
+	Pid       int
+	PPid      int
+	TracerPid int
+	NSpid     []int
+	Threads   int
+	SigBlk    uint64
+	SigIgn    uint64
+	SigCgt    uint64
+}
+
+func ReadStatus(pid int) (*Status, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	return readStatus(b)
+}
+
+func readStatus(b []byte) (*Status, error) {
+	status := &Status{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		k, v := kv[0], strings.TrimSpace(kv[1])
+
+		var err error
+		switch k {
+		case "Name":
+			status.Name = v
+		case "State":
+			if v != "" {
+				status.State = v[0]
+			}
+		case "Tgid":
+			status.Tgid, err = strconv.Atoi(v)
+		case "Pid":
+			status.Pid, err = strconv.Atoi(v)
+		case "PPid":
+			status.PPid, err = strconv.Atoi(v)
+		case "TracerPid":
+			status.TracerPid, err = strconv.Atoi(v)
+		case "NSpid":
+			for _, f := range strings.Fields(v) {
+				var nspid int
+				nspid, err = strconv.Atoi(f)
+				if err != nil {
+					break
+				}
+				status.NSpid = append(status.NSpid, nspid)
+			}
+		case "Threads":
+			status.Threads, err = strconv.Atoi(v)
+		case "SigBlk":
+			status.SigBlk, err = strconv.ParseUint(v, 16, 64)
+		case "SigIgn":
+			status.SigIgn, err = strconv.ParseUint(v, 16, 64)
+		case "SigCgt":
+			status.SigCgt, err = strconv.ParseUint(v, 16, 64)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return status, scanner.Err()
+}
@@ -0,0 +1,39 @@
+package proc
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestReadStatus(t *testing.T) {
+	want := &Status{
+		Name:      "cat",
+		State:     't',
+		Tgid:      2694312,
+		Pid:       2694312,
+		PPid:      2694280,
+		TracerPid: 2694400,
+		NSpid:     []int{2694312, 7},
+		Threads:   1,
+		SigBlk:    0x10000,
+		SigIgn:    0x1000,
+		SigCgt:    0x14000,
+	}
+
+	b, err := ioutil.ReadFile("testdata/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readStatus(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Error(deep.Equal(got, want))
+	}
+}
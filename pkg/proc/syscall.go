@@ -0,0 +1,67 @@
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// A Syscall is /proc/<pid>/task/<tid>/syscall: the system call which a blocked
+// thread is making, if any, and its stack and instruction pointers.  It can be
+// read without ptrace, and so gives some idea of where a thread is when it
+// can't be seized.
+type Syscall struct {
+	// Nr is -1 if the thread is blocked but not in a system call.
+	Nr   int
+	Args [6]uint64
+	SP   uint64
+	PC   uint64
+}
+
+func ReadSyscall(pid, tid int) (*Syscall, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/task/%d/syscall", pid, tid))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSyscall(strings.TrimSpace(string(b)))
+}
+
+func parseSyscall(s string) (*Syscall, error) {
+	if s == "running" {
+		return nil, fmt.Errorf("thread is running")
+	}
+
+	fields := strings.Fields(s)
+
+	sc := &Syscall{}
+
+	var err error
+	sc.Nr, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var values []uint64
+	for _, f := range fields[1:] {
+		v, err := strconv.ParseUint(strings.TrimPrefix(f, "0x"), 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	switch {
+	case sc.Nr == -1 && len(values) == 2:
+	case sc.Nr >= 0 && len(values) == 8:
+		copy(sc.Args[:], values)
+		values = values[6:]
+	default:
+		return nil, fmt.Errorf("invalid syscall line %q", s)
+	}
+
+	sc.SP, sc.PC = values[0], values[1]
+
+	return sc, nil
+}
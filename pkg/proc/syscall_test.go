@@ -0,0 +1,46 @@
+package proc
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestParseSyscall(t *testing.T) {
+	for _, tt := range []struct {
+		s       string
+		want    *Syscall
+		wantErr bool
+	}{
+		{
+			s: "230 0x0 0x0 0x7ffd4d6b9a40 0x7ffd4d6b9a40 0x0 0x0 0x7ffd4d6b9a08 0x7f5c2e4e3503",
+			want: &Syscall{
+				Nr:   230,
+				Args: [6]uint64{0, 0, 0x7ffd4d6b9a40, 0x7ffd4d6b9a40, 0, 0},
+				SP:   0x7ffd4d6b9a08,
+				PC:   0x7f5c2e4e3503,
+			},
+		},
+		{
+			s:    "-1 0x7ffd4d6b9a08 0x401000",
+			want: &Syscall{Nr: -1, SP: 0x7ffd4d6b9a08, PC: 0x401000},
+		},
+		{
+			s:       "running",
+			wantErr: true,
+		},
+		{
+			s:       "0 0x1",
+			wantErr: true,
+		},
+	} {
+		got, err := parseSyscall(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: got error %v", tt.s, err)
+		}
+
+		for _, diff := range deep.Equal(got, tt.want) {
+			t.Errorf("%q: %s", tt.s, diff)
+		}
+	}
+}
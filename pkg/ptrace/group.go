@@ -0,0 +1,64 @@
+package ptrace
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"golang.org/x/sys/unix"
+)
+
+// SeizeAll seizes every process listed by list, which is called repeatedly
+// until it lists no process that hasn't yet been seized, so that children
+// forked meanwhile are caught too.  Processes which exit first are skipped, as
+// is the caller.  Every process is stopped together, and stays stopped until
+// its session is detached.  If any process can't be seized, those already
+// seized are detached again.
+func SeizeAll(list func() ([]int, error)) (_ []*Session, err error) {
+	sessions := map[int]*Session{}
+
+	defer func() {
+		if err != nil {
+			for _, s := range sessions {
+				s.Detach()
+			}
+		}
+	}()
+
+	for {
+		pids, err := list()
+		if err != nil {
+			return nil, err
+		}
+
+		var didWork bool
+		for _, pid := range pids {
+			if _, ok := sessions[pid]; ok || pid == os.Getpid() {
+				continue
+			}
+
+			s, err := Seize(pid)
+			if errors.Is(err, unix.ESRCH) || errors.Is(err, os.ErrNotExist) {
+				continue // the process exited
+			}
+			if err != nil {
+				return nil, err
+			}
+			sessions[pid] = s
+
+			didWork = true
+		}
+
+		if !didWork {
+			break
+		}
+	}
+
+	all := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].pid < all[j].pid })
+
+	return all, nil
+}
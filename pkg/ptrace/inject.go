@@ -0,0 +1,278 @@
+package ptrace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+// insnSyscall is the x86-64 syscall instruction.
+var insnSyscall = []byte{0x0f, 0x05}
+
+// findSyscall returns the address of a syscall instruction in process pid's
+// executable mappings, preferring the vDSO.  Jumping to it rather than writing
+// one means that the target's memory is never modified.
+func findSyscall(pid int) (uint64, error) {
+	smaps, err := proc.ReadSmaps(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	mem, err := proc.Mem(pid)
+	if err != nil {
+		return 0, err
+	}
+	defer mem.Close()
+
+	var candidates []*proc.Smap
+	for _, smap := range smaps {
+		// Executing the vsyscall page is emulated, and only at its entry
+		// points.
+		if smap.Perms&(proc.PermR|proc.PermX) != proc.PermR|proc.PermX || smap.Pathname == "[vsyscall]" {
+			continue
+		}
+
+		if smap.Pathname == "[vdso]" {
+			candidates = append([]*proc.Smap{smap}, candidates...)
+		} else {
+			candidates = append(candidates, smap)
+		}
+	}
+
+	const chunk = 1 << 20
+	buf := make([]byte, chunk+len(insnSyscall)-1)
+
+	for _, smap := range candidates {
+		for addr := smap.Start; addr < smap.End; addr += chunk {
+			n := uint64(len(buf))
+			if smap.End-addr < n {
+				n = smap.End - addr
+			}
+
+			m, err := mem.ReadAt(buf[:n], int64(addr))
+			if err != nil && err != io.EOF {
+				break
+			}
+
+			if i := bytes.Index(buf[:m], insnSyscall); i != -1 {
+				return addr + uint64(i), nil
+			}
+		}
+	}
+
+	return 0, errors.New("no syscall instruction found")
+}
+
+// Syscall makes system call nr with args from within the process, on its main
+// thread if possible, and returns its result.  Every thread must be stopped,
+// as they are after Seize.  The thread's registers are restored afterwards, so
+// that whatever it was doing when seized resumes undisturbed.
+func (s *Session) Syscall(nr uintptr, args ...uintptr) (uintptr, error) {
+	r, _, err := s.syscall(nr, args...)
+	return r, err
+}
+
+// Fork injects a fork into the process, as Syscall, and returns a session for
+// the child, which is a copy-on-write snapshot of the parent's memory.  The
+// child has no exit signal, so the parent isn't told of it; it should be
+// killed with Kill when done with, and then reaped from the parent with
+//
+//	s.Syscall(unix.SYS_WAIT4, uintptr(child.Pid()), 0, unix.WALL, 0)
+func (s *Session) Fork() (*Session, error) {
+	// A bare clone, with no CLONE_* flags and no exit signal.
+	ret, child, err := s.syscall(unix.SYS_CLONE, 0, 0, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if child == 0 {
+		// The clone event wasn't seen, so the child isn't traced.
+		return nil, fmt.Errorf("fork returned %d without a clone event", ret)
+	}
+
+	c := &Session{
+		pid:     child,
+		t:       s.t,
+		threads: map[int]*thread{child: {new: true}},
+	}
+
+	err = register(child, s.t)
+	if err != nil {
+		return nil, err
+	}
+
+	// The child is traced automatically and reports an initial stop.
+	err = c.wait(child)
+	if err != nil {
+		c.Kill()
+		return nil, err
+	}
+	if _, ok := c.threads[child]; !ok {
+		c.Kill()
+		return nil, fmt.Errorf("forked child %d exited", child)
+	}
+
+	return c, nil
+}
+
+// Kill kills the process, rather than detaching it, and reaps it as its
+// tracer.  Its real parent is left to reap it in turn.
+func (s *Session) Kill() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := unix.Kill(s.pid, unix.SIGKILL)
+	if err != nil && err != unix.ESRCH {
+		return err
+	}
+
+	for len(s.threads) > 0 {
+		for _, tid := range s.tids() {
+			err = s.wait(tid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.t != nil {
+		unregister(s.pid, s.t)
+		s.t = nil
+	}
+
+	return nil
+}
+
+// syscall implements Syscall, also returning the pid of any process or thread
+// which the call created, as reported by PTRACE_EVENT_CLONE.
+func (s *Session) syscall(nr uintptr, args ...uintptr) (ret uintptr, child int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tid := s.pid
+	if t, ok := s.threads[tid]; !ok || !t.stopped || t.new {
+		tid = 0
+		for _, id := range s.tids() {
+			if t := s.threads[id]; t.stopped && !t.new {
+				tid = id
+				break
+			}
+		}
+		if tid == 0 {
+			return 0, 0, errors.New("no stopped thread to make the system call")
+		}
+	}
+
+	addr, err := findSyscall(s.pid)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var saved unix.PtraceRegs
+	err = s.do(func() error { return ptrace(unix.PTRACE_GETREGS, tid, 0, uintptr(unsafe.Pointer(&saved))) })
+	if err != nil {
+		return 0, 0, err
+	}
+
+	regs := saved
+	regs.Rip = addr
+	regs.Rax = uint64(nr)
+	// Stop the kernel treating this as the restart of an interrupted system
+	// call.
+	regs.Orig_rax = ^uint64(0)
+	for i, p := range []*uint64{&regs.Rdi, &regs.Rsi, &regs.Rdx, &regs.R10, &regs.R8, &regs.R9} {
+		if i < len(args) {
+			*p = uint64(args[i])
+		}
+	}
+
+	err = s.do(func() error {
+		err := unix.PtraceSetOptions(tid, unix.PTRACE_O_TRACECLONE)
+		if err != nil {
+			return err
+		}
+		return ptrace(unix.PTRACE_SETREGS, tid, 0, uintptr(unsafe.Pointer(&regs)))
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Whatever happens, put the thread back as it was.
+	defer func() {
+		rerr := s.do(func() error { return ptrace(unix.PTRACE_SETREGS, tid, 0, uintptr(unsafe.Pointer(&saved))) })
+		if err == nil {
+			err = rerr
+		}
+	}()
+
+	// Step over the syscall instruction, stopping once it has returned.
+	for {
+		err = s.do(func() error { return unix.PtraceSingleStep(tid) })
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var ws unix.WaitStatus
+		err = s.do(func() (err error) {
+			for {
+				_, err = unix.Wait4(tid, &ws, unix.WALL, nil)
+				if err != unix.EINTR {
+					return err
+				}
+			}
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if ws.Exited() || ws.Signaled() {
+			delete(s.threads, tid)
+			return 0, 0, fmt.Errorf("thread %d exited during system call", tid)
+		}
+		if !ws.Stopped() {
+			continue
+		}
+
+		switch {
+		case ws>>16 == unix.PTRACE_EVENT_CLONE:
+			var msg uint
+			err = s.do(func() (err error) {
+				msg, err = unix.PtraceGetEventMsg(tid)
+				return err
+			})
+			if err != nil {
+				return 0, 0, err
+			}
+			child = int(msg)
+			continue
+
+		case ws>>16 != 0:
+			continue
+
+		case ws.StopSignal() != unix.SIGTRAP:
+			// A signal arrived meanwhile: hold on to it as when seizing.
+			if t := s.threads[tid]; t.sig == 0 {
+				t.sig = ws.StopSignal()
+			}
+			continue
+		}
+
+		break
+	}
+
+	err = s.do(func() error { return ptrace(unix.PTRACE_GETREGS, tid, 0, uintptr(unsafe.Pointer(&regs))) })
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if errno := -int64(regs.Rax); errno > 0 && errno < 4096 {
+		return 0, child, unix.Errno(errno)
+	}
+
+	return uintptr(regs.Rax), child, nil
+}
@@ -1,24 +1,84 @@
 package ptrace
 
 import (
+	"fmt"
 	"runtime"
+	"sync"
 )
 
-var ch chan func()
+// A tracer is an OS thread which makes ptrace requests.  A tracee only accepts
+// requests from the thread which seized it, so each Session has its own
+// tracer, which lets sessions be used concurrently.  A forked child's session
+// shares its parent's.
+type tracer struct {
+	ch   chan func()
+	refs int
+}
 
-func init() {
-	ch = make(chan func())
+func newTracer() *tracer {
+	t := &tracer{ch: make(chan func())}
 
 	go func() {
+		// The goroutine exits still locked, so that the thread is discarded
+		// rather than reused.
 		runtime.LockOSThread()
-		for f := range ch {
+		for f := range t.ch {
 			f()
 		}
 	}()
+
+	return t
 }
 
-func Do(f func() error) error {
+func (t *tracer) do(f func() error) error {
 	errch := make(chan error, 1)
-	ch <- func() { errch <- f() }
+	t.ch <- func() { errch <- f() }
 	return <-errch
 }
+
+// tracers holds the tracer of each seized process, by pid.
+var tracers = struct {
+	sync.Mutex
+	m map[int]*tracer
+}{m: map[int]*tracer{}}
+
+func register(pid int, t *tracer) error {
+	tracers.Lock()
+	defer tracers.Unlock()
+
+	if _, ok := tracers.m[pid]; ok {
+		return fmt.Errorf("process %d is already seized", pid)
+	}
+
+	tracers.m[pid] = t
+	t.refs++
+
+	return nil
+}
+
+// unregister forgets pid's tracer, which exits once no process uses it.
+func unregister(pid int, t *tracer) {
+	tracers.Lock()
+	defer tracers.Unlock()
+
+	delete(tracers.m, pid)
+
+	t.refs--
+	if t.refs == 0 {
+		close(t.ch)
+	}
+}
+
+// Do runs f on the thread which seized process pid, as ptrace requests for
+// its threads must be.
+func Do(pid int, f func() error) error {
+	tracers.Lock()
+	t, ok := tracers.m[pid]
+	tracers.Unlock()
+
+	if !ok {
+		return fmt.Errorf("process %d is not seized", pid)
+	}
+
+	return t.do(f)
+}
@@ -0,0 +1,20 @@
+package ptrace
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// GetRegs returns the general purpose registers of stopped thread tid of
+// process pid.
+func GetRegs(pid, tid int) (*unix.PtraceRegs, error) {
+	regs := &unix.PtraceRegs{}
+
+	err := Do(pid, func() error { return ptrace(unix.PTRACE_GETREGS, tid, 0, uintptr(unsafe.Pointer(regs))) })
+	if err != nil {
+		return nil, err
+	}
+
+	return regs, nil
+}
@@ -1,14 +1,39 @@
 package ptrace
 
 import (
+	"errors"
+	"os"
+
 	"golang.org/x/sys/unix"
 
 	"github.com/jim-minter/gcore/pkg/proc"
 )
 
-func Seize(pid int) ([]int, error) {
-	seized := map[int]struct{}{}
+// Seize seizes and interrupts every thread of process pid, including threads
+// created while it is doing so, and waits for all of them to stop.  If any
+// thread can't be seized, the threads already seized are detached again.
+func Seize(pid int) (_ *Session, err error) {
+	s := &Session{
+		pid:     pid,
+		threads: map[int]*thread{},
+	}
+
+	t := newTracer()
+	err = register(pid, t)
+	if err != nil {
+		close(t.ch)
+		return nil, err
+	}
+	s.t = t
+
+	defer func() {
+		if err != nil {
+			s.Detach()
+		}
+	}()
 
+	// Threads which were still running when we listed the tasks may have
+	// created more threads, so keep going until a pass finds nothing new.
 	for {
 		var didWork bool
 
@@ -18,33 +43,151 @@ func Seize(pid int) ([]int, error) {
 		}
 
 		for _, tid := range tids {
-			if _, ok := seized[tid]; ok {
+			if _, ok := s.threads[tid]; ok {
 				continue
 			}
 
-			err = Do(func() error { return unix.PtraceSeize(tid) })
+			err = s.seize(pid, tid)
 			if err != nil {
 				return nil, err
 			}
 
-			err = Do(func() error { return unix.PtraceInterrupt(tid) })
+			didWork = true
+		}
+
+		if !didWork {
+			break
+		}
+
+		for tid, t := range s.threads {
+			if t.stopped {
+				continue
+			}
+
+			err = s.wait(tid)
 			if err != nil {
 				return nil, err
 			}
+		}
+	}
 
-			seized[tid] = struct{}{}
-			didWork = true
+	if len(s.threads) == 0 {
+		return nil, unix.ESRCH
+	}
+
+	return s, nil
+}
+
+func (s *Session) seize(pid, tid int) error {
+	// A zombie thread group leader can't be stopped, and waiting for it would
+	// block until the whole process exits.
+	stat, err := proc.ReadStat(pid, tid)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, unix.ESRCH) {
+		return nil // the thread exited
+	}
+	if err != nil {
+		return err
+	}
+	if stat.State == 'Z' || stat.State == 'X' {
+		return nil
+	}
+
+	err = s.do(func() error { return unix.PtraceSeize(tid) })
+	if err == unix.ESRCH {
+		return nil // the thread exited
+	}
+	if err != nil {
+		return err
+	}
+	s.threads[tid] = &thread{}
+
+	err = s.do(func() error { return unix.PtraceInterrupt(tid) })
+	if err == unix.ESRCH {
+		return nil // the thread exited; wait will reap it
+	}
+
+	return err
+}
+
+// wait waits for thread tid to enter a ptrace-stop, or to exit, in which case
+// it is forgotten.
+func (s *Session) wait(tid int) error {
+	for {
+		var ws unix.WaitStatus
+
+		err := s.do(func() (err error) {
+			_, err = unix.Wait4(tid, &ws, unix.WALL, nil)
+			return err
+		})
+		switch err {
+		case nil:
+		case unix.EINTR:
+			continue
+		case unix.ECHILD:
+			delete(s.threads, tid)
+			return nil
+		default:
+			return err
 		}
 
-		if !didWork {
-			break
+		if !ws.Exited() && !ws.Signaled() && !ws.Stopped() {
+			continue
 		}
+
+		return s.record(tid, ws)
 	}
+}
+
+// record updates the state of thread tid following a wait status.
+func (s *Session) record(tid int, ws unix.WaitStatus) error {
+	t, ok := s.threads[tid]
+
+	switch {
+	case ws.Exited(), ws.Signaled():
+		delete(s.threads, tid)
+		return nil
+
+	case !ok:
+		// A new thread's initial stop, reported before its creator's
+		// PTRACE_EVENT_CLONE.
+		t = &thread{new: true}
+		s.threads[tid] = t
+	}
+
+	t.stopped = true
 
-	tids := make([]int, 0, len(seized))
-	for tid := range seized {
-		tids = append(tids, tid)
+	switch ws >> 16 {
+	case 0:
+		// Signal-delivery-stop: the thread was about to handle a signal when
+		// it stopped.  Hold on to it so that it can be delivered on detach.
+		t.sig = ws.StopSignal()
+
+	case unix.PTRACE_EVENT_CLONE:
+		// The thread created another while being watched, which is traced
+		// automatically and will report its own initial stop.
+		var msg uint
+		err := s.do(func() (err error) {
+			msg, err = unix.PtraceGetEventMsg(tid)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if _, ok := s.threads[int(msg)]; !ok {
+			s.threads[int(msg)] = &thread{new: true}
+		}
+
+	default:
+		// PTRACE_EVENT_STOP: our interrupt, a group-stop, or a new thread's
+		// initial stop.
+		switch ws.StopSignal() {
+		case unix.SIGSTOP, unix.SIGTSTP, unix.SIGTTIN, unix.SIGTTOU:
+			t.groupStop = !t.new
+		default:
+			t.groupStop = false
+		}
+		t.new = false
 	}
 
-	return tids, nil
+	return nil
 }
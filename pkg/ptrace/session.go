@@ -0,0 +1,162 @@
+package ptrace
+
+import (
+	"sort"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// A Session owns the threads of a process seized by Seize.  The threads stay
+// stopped until Detach is called.
+type Session struct {
+	pid int
+	t   *tracer
+
+	mu      sync.Mutex
+	threads map[int]*thread
+}
+
+type thread struct {
+	stopped bool
+
+	// sig is a signal whose delivery was interrupted by the stop.
+	sig unix.Signal
+
+	// groupStop is set if the thread is in a group-stop, which is resumed
+	// with PTRACE_LISTEN so as not to disturb job control.
+	groupStop bool
+
+	// new is set for a thread created while being watched, until its initial
+	// stop is seen.
+	new bool
+}
+
+func (s *Session) Pid() int {
+	return s.pid
+}
+
+// Signal returns the signal which stopped thread tid was about to handle when
+// it stopped, if any.  It is delivered when the thread is detached.
+func (s *Session) Signal(tid int) unix.Signal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.threads[tid]; ok {
+		return t.sig
+	}
+	return 0
+}
+
+// Tids returns the seized thread IDs: the main thread first, then the others
+// in ascending order.  Thread IDs wrap around, so the main thread's isn't
+// necessarily the lowest.
+func (s *Session) Tids() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tids()
+}
+
+func (s *Session) tids() []int {
+	tids := make([]int, 0, len(s.threads))
+	for tid := range s.threads {
+		if tid != s.pid {
+			tids = append(tids, tid)
+		}
+	}
+	sort.Ints(tids)
+
+	if _, ok := s.threads[s.pid]; ok {
+		tids = append([]int{s.pid}, tids...)
+	}
+
+	return tids
+}
+
+// Detach detaches every seized thread, resuming the process.  It is safe to
+// call more than once; subsequent calls do nothing.
+func (s *Session) Detach() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rv error
+
+	for tid := range s.threads {
+		err := s.detach(tid)
+		if err != nil && rv == nil {
+			rv = err
+		}
+
+		delete(s.threads, tid)
+	}
+
+	if s.t != nil {
+		unregister(s.pid, s.t)
+		s.t = nil
+	}
+
+	return rv
+}
+
+// Close is equivalent to Detach, for use with defer.
+func (s *Session) Close() error {
+	return s.Detach()
+}
+
+func (s *Session) detach(tid int) error {
+	// PTRACE_DETACH fails unless the tracee is in a ptrace-stop, which may not
+	// yet be the case if Seize is rolling back.
+	if !s.threads[tid].stopped {
+		err := s.wait(tid)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := s.threads[tid]; !ok {
+			return nil
+		}
+	}
+
+	err := s.do(func() error { return ptrace(unix.PTRACE_DETACH, tid, 0, uintptr(s.threads[tid].sig)) })
+	if err == unix.ESRCH {
+		return nil // the thread was killed while stopped
+	}
+
+	return err
+}
+
+// PeekData reads the process's memory at addr into b with PTRACE_PEEKDATA, a
+// word at a time.  It suits proc.MemReader's Peek, where neither
+// process_vm_readv nor /proc/<pid>/mem may be used.
+func (s *Session) PeekData(b []byte, addr uintptr) (n int, err error) {
+	tids := s.Tids()
+	if len(tids) == 0 {
+		return 0, unix.ESRCH
+	}
+
+	err = s.do(func() (err error) {
+		n, err = unix.PtracePeekData(tids[0], addr, b)
+		return err
+	})
+
+	return n, err
+}
+
+// do runs f on the session's tracer.
+func (s *Session) do(f func() error) error {
+	if s.t == nil {
+		return unix.ESRCH
+	}
+	return s.t.do(f)
+}
+
+func ptrace(request int, pid int, addr, data uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, uintptr(request), uintptr(pid), addr, data, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package ptrace
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTids(t *testing.T) {
+	// Thread IDs have wrapped around since the main thread started.
+	s := &Session{
+		pid: 32000,
+		threads: map[int]*thread{
+			32000: {},
+			32001: {},
+			300:   {},
+			12:    {},
+		},
+	}
+
+	want := []int{32000, 12, 300, 32001}
+	if got := s.Tids(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
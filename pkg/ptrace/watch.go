@@ -0,0 +1,186 @@
+package ptrace
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+)
+
+// ExitError is returned by WaitSignal if the process exits first.
+type ExitError struct {
+	Status unix.WaitStatus
+}
+
+func (e *ExitError) Error() string {
+	if e.Status.Signaled() {
+		return fmt.Sprintf("process killed by %v", e.Status.Signal())
+	}
+	return fmt.Sprintf("process exited with status %d", e.Status.ExitStatus())
+}
+
+// WaitSignal resumes the seized threads and watches them, tracing new threads
+// as they are created, until one is about to handle a signal for which fatal
+// returns true.  It then stops every thread again and returns that thread's
+// ID; the signal is delivered when the thread is detached.  Other signals are
+// delivered as usual.  If ctx is done first, the threads are stopped and ctx's
+// error is returned.
+func (s *Session) WaitSignal(ctx context.Context, fatal func(tid int, sig unix.Signal) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A thread may already have been about to handle the signal when seized.
+	for _, tid := range s.tids() {
+		if sig := s.threads[tid].sig; sig != 0 && fatal(tid, sig) {
+			return tid, nil
+		}
+	}
+
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, unix.SIGCHLD)
+	defer signal.Stop(sigch)
+
+	for _, tid := range s.tids() {
+		err := s.do(func() error { return unix.PtraceSetOptions(tid, unix.PTRACE_O_TRACECLONE) })
+		if err != nil && err != unix.ESRCH {
+			return 0, err
+		}
+
+		err = s.resume(tid)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var status unix.WaitStatus
+
+	for len(s.threads) > 0 {
+		var didWork bool
+
+		for _, tid := range s.tids() {
+			var ws unix.WaitStatus
+			var wpid int
+
+			err := s.do(func() (err error) {
+				wpid, err = unix.Wait4(tid, &ws, unix.WALL|unix.WNOHANG, nil)
+				return err
+			})
+			switch err {
+			case nil:
+			case unix.EINTR:
+				continue
+			case unix.ECHILD:
+				delete(s.threads, tid)
+				continue
+			default:
+				return 0, err
+			}
+
+			if wpid == 0 || (!ws.Exited() && !ws.Signaled() && !ws.Stopped()) {
+				continue
+			}
+			didWork = true
+
+			if tid == s.pid {
+				status = ws
+			}
+
+			err = s.record(tid, ws)
+			if err != nil {
+				return 0, err
+			}
+
+			t, ok := s.threads[tid]
+			if !ok {
+				continue
+			}
+
+			if t.sig != 0 && fatal(tid, t.sig) {
+				return tid, s.stopAll()
+			}
+
+			err = s.resume(tid)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		if didWork {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			err := s.stopAll()
+			if err != nil {
+				return 0, err
+			}
+			return 0, ctx.Err()
+
+		case <-sigch:
+		}
+	}
+
+	return 0, &ExitError{Status: status}
+}
+
+// resume restarts stopped thread tid, delivering any signal it holds.
+func (s *Session) resume(tid int) error {
+	t := s.threads[tid]
+
+	err := s.do(func() error {
+		if t.groupStop {
+			return ptrace(unix.PTRACE_LISTEN, tid, 0, 0)
+		}
+		return unix.PtraceCont(tid, int(t.sig))
+	})
+	if err != nil && err != unix.ESRCH { // ESRCH: killed while stopped
+		return err
+	}
+
+	t.stopped, t.sig = false, 0
+
+	return nil
+}
+
+// stopAll interrupts every running thread, including any created meanwhile,
+// and waits for them to stop.
+func (s *Session) stopAll() error {
+	for {
+		var running []int
+		for _, tid := range s.tids() {
+			if !s.threads[tid].stopped {
+				running = append(running, tid)
+			}
+		}
+
+		if len(running) == 0 {
+			return nil
+		}
+
+		for _, tid := range running {
+			// A new thread stops by itself once it starts.
+			if s.threads[tid].new {
+				continue
+			}
+
+			err := s.do(func() error { return unix.PtraceInterrupt(tid) })
+			if err != nil && err != unix.ESRCH {
+				return err
+			}
+		}
+
+		for _, tid := range running {
+			if _, ok := s.threads[tid]; !ok {
+				continue
+			}
+
+			err := s.wait(tid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package stack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// DWARF register numbers on x86_64.
+const (
+	regRbp = 6
+	regRsp = 7
+	regRA  = 16
+
+	numRegs = 17
+)
+
+// Pointer encodings used in .eh_frame.
+const (
+	peAbsptr  = 0x00
+	peUleb128 = 0x01
+	peUdata2  = 0x02
+	peUdata4  = 0x03
+	peUdata8  = 0x04
+	peSleb128 = 0x09
+	peSdata2  = 0x0a
+	peSdata4  = 0x0b
+	peSdata8  = 0x0c
+	pePcrel   = 0x10
+	peOmit    = 0xff
+)
+
+type cie struct {
+	codeAlign    uint64
+	dataAlign    int64
+	ra           uint64
+	fdeEncoding  byte
+	augmentation bool
+	signal       bool
+	instructions []byte
+}
+
+type fde struct {
+	start        uint64
+	end          uint64
+	cie          *cie
+	instructions []byte
+}
+
+type ruleKind int
+
+const (
+	ruleUndefined ruleKind = iota
+	ruleSameValue
+	ruleOffset
+	ruleValOffset
+	ruleRegister
+	ruleExpression
+	ruleValExpression
+)
+
+type rule struct {
+	kind   ruleKind
+	offset int64
+	reg    uint64
+	expr   []byte
+}
+
+// row is a row of the CFI table: how to compute the CFA and recover each
+// register at a given address.
+type row struct {
+	cfaReg    uint64
+	cfaOffset int64
+	cfaExpr   []byte
+	regs      [numRegs]rule
+}
+
+// buf decodes little-endian DWARF data.
+type buf struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (b *buf) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+	b.off = len(b.b)
+}
+
+func (b *buf) bytes(n int) []byte {
+	if n < 0 || b.off+n > len(b.b) {
+		b.fail(errors.New("truncated CFI"))
+		return nil
+	}
+	p := b.b[b.off : b.off+n]
+	b.off += n
+	return p
+}
+
+func (b *buf) u8() byte {
+	if p := b.bytes(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (b *buf) u16() uint16 {
+	if p := b.bytes(2); p != nil {
+		return binary.LittleEndian.Uint16(p)
+	}
+	return 0
+}
+
+func (b *buf) u32() uint32 {
+	if p := b.bytes(4); p != nil {
+		return binary.LittleEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (b *buf) u64() uint64 {
+	if p := b.bytes(8); p != nil {
+		return binary.LittleEndian.Uint64(p)
+	}
+	return 0
+}
+
+func (b *buf) uleb() (v uint64) {
+	for shift := uint(0); ; shift += 7 {
+		c := b.u8()
+		if b.err != nil {
+			return 0
+		}
+		if shift < 64 {
+			v |= uint64(c&0x7f) << shift
+		}
+		if c&0x80 == 0 {
+			return v
+		}
+	}
+}
+
+func (b *buf) sleb() (v int64) {
+	var shift uint
+	var c byte
+	for {
+		c = b.u8()
+		if b.err != nil {
+			return 0
+		}
+		if shift < 64 {
+			v |= int64(c&0x7f) << shift
+		}
+		shift += 7
+		if c&0x80 == 0 {
+			break
+		}
+	}
+	if shift < 64 && c&0x40 != 0 {
+		v |= -1 << shift
+	}
+	return v
+}
+
+func (b *buf) cstring() string {
+	for i := b.off; i < len(b.b); i++ {
+		if b.b[i] == 0 {
+			s := string(b.b[b.off:i])
+			b.off = i + 1
+			return s
+		}
+	}
+	b.fail(errors.New("unterminated string in CFI"))
+	return ""
+}
+
+// pointer reads a pointer with encoding enc.  addr is the address at which
+// the section is loaded, for pc-relative pointers.
+func (b *buf) pointer(enc byte, addr uint64) uint64 {
+	if enc == peOmit {
+		return 0
+	}
+
+	pos := addr + uint64(b.off)
+
+	var v uint64
+	switch enc & 0x0f {
+	case peAbsptr, peUdata8, peSdata8:
+		v = b.u64()
+	case peUleb128:
+		v = b.uleb()
+	case peUdata2:
+		v = uint64(b.u16())
+	case peUdata4:
+		v = uint64(b.u32())
+	case peSleb128:
+		v = uint64(b.sleb())
+	case peSdata2:
+		v = uint64(int16(b.u16()))
+	case peSdata4:
+		v = uint64(int32(b.u32()))
+	default:
+		b.fail(fmt.Errorf("unsupported pointer encoding %#x", enc))
+		return 0
+	}
+
+	switch enc & 0x70 {
+	case 0:
+	case pePcrel:
+		v += pos
+	default:
+		b.fail(fmt.Errorf("unsupported pointer encoding %#x", enc))
+	}
+
+	return v
+}
+
+// parseFrames parses .eh_frame (ehFrame true) or .debug_frame section data,
+// loaded at addr, and returns its FDEs sorted by address.
+func parseFrames(data []byte, addr uint64, ehFrame bool) ([]*fde, error) {
+	cies := map[int]*cie{}
+	var fdes []*fde
+
+	b := &buf{b: data}
+	for b.off < len(b.b) {
+		start := b.off
+
+		length := uint64(b.u32())
+		if length == 0 && ehFrame {
+			break
+		}
+
+		is64 := length == 0xffffffff
+		if is64 {
+			length = b.u64()
+		}
+
+		if b.err != nil || length > uint64(len(b.b)-b.off) {
+			return nil, errors.New("truncated CFI entry")
+		}
+
+		idOff := b.off
+		entry := &buf{b: b.b[:b.off+int(length)], off: b.off}
+		b.off += int(length)
+
+		var id uint64
+		if is64 {
+			id = entry.u64()
+		} else {
+			id = uint64(entry.u32())
+		}
+
+		var isCIE bool
+		if ehFrame {
+			isCIE = id == 0
+		} else {
+			isCIE = id == 0xffffffff || id == 0xffffffffffffffff
+		}
+
+		if isCIE {
+			c, err := parseCIE(entry, ehFrame)
+			if err != nil {
+				return nil, err
+			}
+			cies[start] = c
+			continue
+		}
+
+		var cieOff int
+		if ehFrame {
+			cieOff = idOff - int(id)
+		} else {
+			cieOff = int(id)
+		}
+
+		c := cies[cieOff]
+		if c == nil {
+			sub := &buf{b: data, off: cieOff}
+			var err error
+			c, err = parseCIEAt(sub, ehFrame)
+			if err != nil {
+				return nil, err
+			}
+			cies[cieOff] = c
+		}
+
+		f := &fde{cie: c}
+		if ehFrame {
+			f.start = entry.pointer(c.fdeEncoding, addr)
+			f.end = f.start + entry.pointer(c.fdeEncoding&0x0f, 0)
+		} else {
+			f.start = entry.u64()
+			f.end = f.start + entry.u64()
+		}
+
+		if c.augmentation {
+			entry.bytes(int(entry.uleb()))
+		}
+
+		if entry.err != nil {
+			return nil, entry.err
+		}
+
+		f.instructions = entry.b[entry.off:]
+		if f.start != f.end {
+			fdes = append(fdes, f)
+		}
+	}
+
+	sort.Slice(fdes, func(i, j int) bool { return fdes[i].start < fdes[j].start })
+
+	return fdes, nil
+}
+
+func parseCIEAt(b *buf, ehFrame bool) (*cie, error) {
+	length := uint64(b.u32())
+	is64 := length == 0xffffffff
+	if is64 {
+		length = b.u64()
+	}
+	if b.err != nil || length > uint64(len(b.b)-b.off) {
+		return nil, errors.New("invalid CIE pointer")
+	}
+
+	entry := &buf{b: b.b[:b.off+int(length)], off: b.off}
+	if is64 {
+		entry.u64()
+	} else {
+		entry.u32()
+	}
+
+	return parseCIE(entry, ehFrame)
+}
+
+func parseCIE(b *buf, ehFrame bool) (*cie, error) {
+	c := &cie{fdeEncoding: peAbsptr}
+
+	version := b.u8()
+	aug := b.cstring()
+
+	if !ehFrame && version >= 4 {
+		b.u8() // address_size
+		b.u8() // segment_selector_size
+	}
+
+	c.codeAlign = b.uleb()
+	c.dataAlign = b.sleb()
+	if version == 1 {
+		c.ra = uint64(b.u8())
+	} else {
+		c.ra = b.uleb()
+	}
+
+	if len(aug) > 0 && aug[0] == 'z' {
+		c.augmentation = true
+
+		n := b.uleb()
+		augData := &buf{b: b.bytes(int(n))}
+
+		for _, a := range aug[1:] {
+			switch a {
+			case 'L':
+				augData.u8()
+			case 'P':
+				augData.pointer(augData.u8()&0x0f, 0)
+			case 'R':
+				c.fdeEncoding = augData.u8()
+			case 'S':
+				c.signal = true
+			}
+		}
+	} else if aug != "" {
+		return nil, fmt.Errorf("unsupported CIE augmentation %q", aug)
+	}
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	c.instructions = b.b[b.off:]
+
+	return c, nil
+}
+
+// find returns the FDE covering pc, if any.
+func find(fdes []*fde, pc uint64) *fde {
+	i := sort.Search(len(fdes), func(i int) bool { return fdes[i].end > pc })
+	if i < len(fdes) && fdes[i].start <= pc {
+		return fdes[i]
+	}
+	return nil
+}
+
+// execute runs the CIE's and FDE's instructions up to pc and returns the
+// resulting row.
+func (f *fde) execute(pc uint64) (*row, error) {
+	r := &row{}
+	for i := range r.regs {
+		r.regs[i].kind = ruleSameValue
+	}
+
+	err := r.run(f.cie, f.cie.instructions, f.start, pc, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	initial := *r
+
+	err = r.run(f.cie, f.instructions, f.start, pc, &initial)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *row) setReg(reg uint64, ru rule) {
+	if reg < numRegs {
+		r.regs[reg] = ru
+	}
+}
+
+func (r *row) run(c *cie, instructions []byte, loc, pc uint64, initial *row) error {
+	var stack []row
+
+	b := &buf{b: instructions}
+	for b.off < len(b.b) {
+		op := b.u8()
+
+		switch op & 0xc0 {
+		case 0x40: // DW_CFA_advance_loc
+			loc += uint64(op&0x3f) * c.codeAlign
+			if loc > pc {
+				return nil
+			}
+			continue
+
+		case 0x80: // DW_CFA_offset
+			r.setReg(uint64(op&0x3f), rule{kind: ruleOffset, offset: int64(b.uleb()) * c.dataAlign})
+			continue
+
+		case 0xc0: // DW_CFA_restore
+			if initial != nil && uint64(op&0x3f) < numRegs {
+				r.regs[op&0x3f] = initial.regs[op&0x3f]
+			}
+			continue
+		}
+
+		switch op {
+		case 0x00: // DW_CFA_nop
+
+		case 0x01: // DW_CFA_set_loc
+			loc = b.pointer(c.fdeEncoding&^pePcrel, 0)
+			if loc > pc {
+				return nil
+			}
+
+		case 0x02, 0x03, 0x04: // DW_CFA_advance_loc1, 2, 4
+			var delta uint64
+			switch op {
+			case 0x02:
+				delta = uint64(b.u8())
+			case 0x03:
+				delta = uint64(b.u16())
+			case 0x04:
+				delta = uint64(b.u32())
+			}
+			loc += delta * c.codeAlign
+			if loc > pc {
+				return nil
+			}
+
+		case 0x05: // DW_CFA_offset_extended
+			reg := b.uleb()
+			r.setReg(reg, rule{kind: ruleOffset, offset: int64(b.uleb()) * c.dataAlign})
+
+		case 0x06: // DW_CFA_restore_extended
+			reg := b.uleb()
+			if initial != nil && reg < numRegs {
+				r.regs[reg] = initial.regs[reg]
+			}
+
+		case 0x07: // DW_CFA_undefined
+			r.setReg(b.uleb(), rule{kind: ruleUndefined})
+
+		case 0x08: // DW_CFA_same_value
+			r.setReg(b.uleb(), rule{kind: ruleSameValue})
+
+		case 0x09: // DW_CFA_register
+			reg := b.uleb()
+			r.setReg(reg, rule{kind: ruleRegister, reg: b.uleb()})
+
+		case 0x0a: // DW_CFA_remember_state
+			stack = append(stack, *r)
+
+		case 0x0b: // DW_CFA_restore_state
+			if len(stack) == 0 {
+				return errors.New("DW_CFA_restore_state with empty stack")
+			}
+			*r = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+		case 0x0c: // DW_CFA_def_cfa
+			r.cfaReg = b.uleb()
+			r.cfaOffset = int64(b.uleb())
+			r.cfaExpr = nil
+
+		case 0x0d: // DW_CFA_def_cfa_register
+			r.cfaReg = b.uleb()
+			r.cfaExpr = nil
+
+		case 0x0e: // DW_CFA_def_cfa_offset
+			r.cfaOffset = int64(b.uleb())
+
+		case 0x0f: // DW_CFA_def_cfa_expression
+			r.cfaExpr = b.bytes(int(b.uleb()))
+
+		case 0x10: // DW_CFA_expression
+			reg := b.uleb()
+			r.setReg(reg, rule{kind: ruleExpression, expr: b.bytes(int(b.uleb()))})
+
+		case 0x11: // DW_CFA_offset_extended_sf
+			reg := b.uleb()
+			r.setReg(reg, rule{kind: ruleOffset, offset: b.sleb() * c.dataAlign})
+
+		case 0x12: // DW_CFA_def_cfa_sf
+			r.cfaReg = b.uleb()
+			r.cfaOffset = b.sleb() * c.dataAlign
+			r.cfaExpr = nil
+
+		case 0x13: // DW_CFA_def_cfa_offset_sf
+			r.cfaOffset = b.sleb() * c.dataAlign
+
+		case 0x14: // DW_CFA_val_offset
+			reg := b.uleb()
+			r.setReg(reg, rule{kind: ruleValOffset, offset: int64(b.uleb()) * c.dataAlign})
+
+		case 0x15: // DW_CFA_val_offset_sf
+			reg := b.uleb()
+			r.setReg(reg, rule{kind: ruleValOffset, offset: b.sleb() * c.dataAlign})
+
+		case 0x16: // DW_CFA_val_expression
+			reg := b.uleb()
+			r.setReg(reg, rule{kind: ruleValExpression, expr: b.bytes(int(b.uleb()))})
+
+		case 0x2e: // DW_CFA_GNU_args_size
+			b.uleb()
+
+		case 0x2f: // DW_CFA_GNU_negative_offset_extended
+			reg := b.uleb()
+			r.setReg(reg, rule{kind: ruleOffset, offset: -int64(b.uleb()) * c.dataAlign})
+
+		default:
+			return fmt.Errorf("unsupported CFA instruction %#x", op)
+		}
+	}
+
+	return b.err
+}
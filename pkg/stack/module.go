@@ -0,0 +1,248 @@
+package stack
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+// A module is an ELF object mapped into the target: an executable, a shared
+// library or the vDSO.
+type module struct {
+	name string
+	bias uint64
+
+	f      *elf.File
+	closer io.Closer
+
+	fdesOnce sync.Once
+	fdes     []*fde
+	fdesErr  error
+
+	symsOnce sync.Once
+	syms     []elf.Symbol
+}
+
+// mapping is an executable mapping of a module.
+type mapping struct {
+	start uint64
+	end   uint64
+	m     *module
+}
+
+// Modules maps a target's executable mappings to the objects mapped there.
+type Modules struct {
+	mappings []mapping
+	byName   map[string]*module
+}
+
+// LoadModules opens the objects behind each of process pid's executable
+// mappings.  Files are opened via /proc/<pid>/root so that the target's view
+// of the filesystem is used, and the vDSO is read from the target's memory.
+func LoadModules(pid int, mem io.ReaderAt) (*Modules, error) {
+	smaps, err := proc.ReadSmaps(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := NewModules()
+
+	for _, smap := range smaps {
+		if smap.Perms&proc.PermX == 0 {
+			continue
+		}
+
+		m, ok := ms.byName[smap.Pathname]
+		if !ok {
+			m, err = openModule(pid, mem, smap)
+			if err != nil {
+				// Frames in this mapping can still be unwound with frame
+				// pointers, just not symbolized.
+				m = &module{name: smap.Pathname}
+			}
+			ms.byName[smap.Pathname] = m
+		}
+
+		if m.f != nil && smap.Pathname != "[vdso]" {
+			bias, ok := loadBias(m.f, smap)
+			if ok {
+				m.bias = bias
+			}
+		}
+
+		ms.mappings = append(ms.mappings, mapping{start: smap.Start, end: smap.End, m: m})
+	}
+
+	sort.Slice(ms.mappings, func(i, j int) bool { return ms.mappings[i].start < ms.mappings[j].start })
+
+	return ms, nil
+}
+
+func openModule(pid int, mem io.ReaderAt, smap *proc.Smap) (*module, error) {
+	m := &module{name: smap.Pathname}
+
+	switch {
+	case smap.Pathname == "[vdso]":
+		f, err := elf.NewFile(io.NewSectionReader(mem, int64(smap.Start), int64(smap.End-smap.Start)))
+		if err != nil {
+			return nil, err
+		}
+		m.f = f
+
+		for _, prog := range f.Progs {
+			if prog.Type == elf.PT_LOAD {
+				m.bias = smap.Start - prog.Vaddr&^uint64(os.Getpagesize()-1)
+				break
+			}
+		}
+
+	case smap.IsFile():
+		path := fmt.Sprintf("/proc/%d/root%s", pid, smap.Pathname)
+		if strings.HasSuffix(smap.Pathname, " (deleted)") {
+			path = fmt.Sprintf("/proc/%d/map_files/%x-%x", pid, smap.Start, smap.End)
+		}
+
+		f, err := elf.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		m.f, m.closer = f, f
+
+	default:
+		return nil, fmt.Errorf("%q: not a file", smap.Pathname)
+	}
+
+	return m, nil
+}
+
+// loadBias returns the difference between the addresses at which smap is
+// mapped and the addresses in f.
+func loadBias(f *elf.File, smap *proc.Smap) (uint64, bool) {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+
+		pagesize := uint64(os.Getpagesize())
+		off := prog.Off &^ (pagesize - 1)
+
+		if uint64(smap.Offset) >= off && uint64(smap.Offset) < prog.Off+prog.Filesz {
+			return smap.Start - (prog.Vaddr&^(pagesize-1) + uint64(smap.Offset) - off), true
+		}
+	}
+
+	return 0, false
+}
+
+// NewModules returns an empty set of modules, e.g. for populating from a core
+// with Add.
+func NewModules() *Modules {
+	return &Modules{byName: map[string]*module{}}
+}
+
+// Add records that f is mapped at [start, end) with the given load bias.  f
+// isn't closed by Close.
+func (ms *Modules) Add(name string, f *elf.File, start, end, bias uint64) {
+	m, ok := ms.byName[name]
+	if !ok {
+		m = &module{name: name, f: f, bias: bias}
+		ms.byName[name] = m
+	}
+
+	ms.mappings = append(ms.mappings, mapping{start: start, end: end, m: m})
+	sort.Slice(ms.mappings, func(i, j int) bool { return ms.mappings[i].start < ms.mappings[j].start })
+}
+
+func (ms *Modules) Close() {
+	for _, m := range ms.byName {
+		if m.closer != nil {
+			m.closer.Close()
+		}
+	}
+}
+
+// lookup returns the module mapped at pc.
+func (ms *Modules) lookup(pc uint64) *module {
+	i := sort.Search(len(ms.mappings), func(i int) bool { return ms.mappings[i].end > pc })
+	if i < len(ms.mappings) && ms.mappings[i].start <= pc {
+		return ms.mappings[i].m
+	}
+	return nil
+}
+
+// LoadFrames parses each module's unwind tables, which otherwise happens on
+// first use, e.g. so that it needn't happen while the target is stopped.
+func (ms *Modules) LoadFrames() {
+	for _, m := range ms.byName {
+		m.frames()
+	}
+}
+
+// frames returns the module's FDEs, preferring .eh_frame to .debug_frame.
+func (m *module) frames() ([]*fde, error) {
+	m.fdesOnce.Do(func() {
+		if m.f == nil {
+			return
+		}
+
+		if s := m.f.Section(".eh_frame"); s != nil && s.Type != elf.SHT_NOBITS {
+			data, err := s.Data()
+			if err != nil {
+				m.fdesErr = err
+				return
+			}
+			m.fdes, m.fdesErr = parseFrames(data, s.Addr, true)
+			return
+		}
+
+		if s := m.f.Section(".debug_frame"); s != nil && s.Type != elf.SHT_NOBITS {
+			data, err := s.Data()
+			if err != nil {
+				m.fdesErr = err
+				return
+			}
+			m.fdes, m.fdesErr = parseFrames(data, s.Addr, false)
+		}
+	})
+
+	return m.fdes, m.fdesErr
+}
+
+// symbolize returns the function containing addr, an address in the module,
+// and addr's offset into it.
+func (m *module) symbolize(addr uint64) (string, uint64, bool) {
+	m.symsOnce.Do(func() {
+		if m.f == nil {
+			return
+		}
+
+		syms, _ := m.f.Symbols()
+		dynsyms, _ := m.f.DynamicSymbols()
+
+		for _, sym := range append(syms, dynsyms...) {
+			if elf.ST_TYPE(sym.Info) == elf.STT_FUNC && sym.Value != 0 {
+				m.syms = append(m.syms, sym)
+			}
+		}
+
+		sort.SliceStable(m.syms, func(i, j int) bool { return m.syms[i].Value < m.syms[j].Value })
+	})
+
+	i := sort.Search(len(m.syms), func(i int) bool { return m.syms[i].Value > addr }) - 1
+	if i < 0 {
+		return "", 0, false
+	}
+
+	sym := m.syms[i]
+	if sym.Size != 0 && addr >= sym.Value+sym.Size {
+		return "", 0, false
+	}
+
+	return sym.Name, addr - sym.Value, true
+}
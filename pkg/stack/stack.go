@@ -0,0 +1,146 @@
+// Package stack captures the stack traces of a running process's threads.
+package stack
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jim-minter/gcore/pkg/proc"
+	"github.com/jim-minter/gcore/pkg/ptrace"
+)
+
+type Frame struct {
+	PC uint64
+	SP uint64
+
+	// Module is the path of the object mapped at PC, if any.
+	Module string
+
+	// Function and Offset locate PC within a function, if a symbol was found.
+	Function string
+	Offset   uint64
+}
+
+func (f *Frame) String() string {
+	s := fmt.Sprintf("%#016x", f.PC)
+	if f.Function != "" {
+		s += fmt.Sprintf(" in %s+%#x", f.Function, f.Offset)
+	}
+	if f.Module != "" {
+		s += fmt.Sprintf(" (%s)", f.Module)
+	}
+	return s
+}
+
+type Thread struct {
+	Tid    int
+	Name   string
+	Frames []Frame
+
+	// Err is set if unwinding stopped before reaching the outermost frame.
+	Err error
+}
+
+type Result struct {
+	Threads []*Thread
+
+	// Pause is how long the target was stopped for.
+	Pause time.Duration
+}
+
+// Trace stops process pid, unwinds each of its threads' stacks and resumes
+// it.  Executable mappings are opened and their unwind tables parsed before
+// the process is stopped, and frames are symbolized after it resumes, so that
+// it is only stopped while registers and stack memory are read.
+func Trace(pid int) (result *Result, err error) {
+	mem, err := proc.Mem(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer mem.Close()
+
+	ms, err := LoadModules(pid, mem)
+	if err != nil {
+		return nil, err
+	}
+	defer ms.Close()
+
+	ms.LoadFrames()
+
+	result = &Result{}
+
+	err = capture(pid, mem, ms, result)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range result.Threads {
+		ms.Symbolize(t.Frames)
+	}
+
+	return result, nil
+}
+
+// Symbolize fills in the module, function and offset of each frame returned
+// by Unwind.
+func (ms *Modules) Symbolize(frames []Frame) {
+	for i := range frames {
+		f := &frames[i]
+
+		m := ms.lookup(f.PC)
+		if m == nil {
+			continue
+		}
+		f.Module = m.name
+
+		// Symbolize return addresses by their call instruction.
+		addr := f.PC - m.bias
+		if i > 0 {
+			addr--
+		}
+
+		if name, off, ok := m.symbolize(addr); ok {
+			f.Function = name
+			f.Offset = off
+			if i > 0 {
+				f.Offset++
+			}
+		}
+	}
+}
+
+func capture(pid int, mem io.ReaderAt, ms *Modules, result *Result) (err error) {
+	start := time.Now()
+
+	s, err := ptrace.Seize(pid)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if derr := s.Detach(); err == nil {
+			err = derr
+		}
+		result.Pause = time.Since(start)
+	}()
+
+	for _, tid := range s.Tids() {
+		t := &Thread{Tid: tid}
+
+		if stat, err := proc.ReadStat(pid, tid); err == nil {
+			t.Name = stat.Comm
+		}
+
+		regs, err := ptrace.GetRegs(pid, tid)
+		if err != nil {
+			return err
+		}
+
+		t.Frames, t.Err = ms.Unwind(mem, regs)
+
+		result.Threads = append(result.Threads, t)
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package stack
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+func TestTrace(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+
+	err := cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for {
+		stat, err := proc.ReadStat(cmd.Process.Pid, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stat.Comm == "sleep" && stat.State == 'S' {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	result, err := Trace(cmd.Process.Pid)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Threads) != 1 {
+		t.Fatalf("got %d threads", len(result.Threads))
+	}
+
+	th := result.Threads[0]
+	if th.Err != nil {
+		t.Error(th.Err)
+	}
+
+	// sleep -> main -> __libc_start_main -> _start, at least.
+	if len(th.Frames) < 3 {
+		t.Errorf("got %d frames: %v", len(th.Frames), th.Frames)
+	}
+
+	for _, f := range th.Frames {
+		if f.Module == "" {
+			t.Errorf("frame %s not in a module", &f)
+		}
+	}
+
+	status, err := proc.ReadStatus(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.TracerPid != 0 {
+		t.Errorf("target still traced by %d", status.TracerPid)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	// glibc's PLT CFA: rsp + 8 + ((rip & 15) >= 11 ? 8 : 0)
+	expr := []byte{0x77, 0x08, 0x80, 0x00, 0x3f, 0x1a, 0x3b, 0x2a, 0x33, 0x24, 0x22}
+
+	for _, tt := range []struct {
+		rip  uint64
+		want uint64
+	}{
+		{rip: 0x1000, want: 0x7008},
+		{rip: 0x100b, want: 0x7010},
+	} {
+		r := &regs{}
+		r.set(regRsp, 0x7000)
+		r.set(regRA, tt.rip)
+
+		got, err := evaluate(nil, expr, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("rip %#x: got %#x, want %#x", tt.rip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package stack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxFrames bounds the unwinding of corrupt or cyclic stacks.
+const maxFrames = 1024
+
+// regs holds the DWARF-numbered registers of a frame.
+type regs struct {
+	v     [numRegs]uint64
+	valid [numRegs]bool
+}
+
+func (r *regs) set(reg uint64, v uint64) {
+	r.v[reg], r.valid[reg] = v, true
+}
+
+func newRegs(pt *unix.PtraceRegs) *regs {
+	r := &regs{}
+	for reg, v := range []uint64{
+		pt.Rax, pt.Rdx, pt.Rcx, pt.Rbx, pt.Rsi, pt.Rdi, pt.Rbp, pt.Rsp,
+		pt.R8, pt.R9, pt.R10, pt.R11, pt.R12, pt.R13, pt.R14, pt.R15,
+		pt.Rip,
+	} {
+		r.set(uint64(reg), v)
+	}
+	return r
+}
+
+func readUint64(mem io.ReaderAt, addr uint64) (uint64, error) {
+	var b [8]byte
+	_, err := mem.ReadAt(b[:], int64(addr))
+	if err != nil {
+		return 0, fmt.Errorf("reading %#x: %v", addr, err)
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+// Unwind walks the stack of a thread interrupted with registers pt, returning
+// a frame for each return address.  It returns the frames found so far along
+// with any error which stopped it.
+func (ms *Modules) Unwind(mem io.ReaderAt, pt *unix.PtraceRegs) ([]Frame, error) {
+	return unwind(mem, ms, newRegs(pt), true)
+}
+
+// unwind walks the stack from r.  signal is set if r's pc hasn't been called
+// from elsewhere.
+func unwind(mem io.ReaderAt, ms *Modules, r *regs, signal bool) ([]Frame, error) {
+	var frames []Frame
+
+	for len(frames) < maxFrames {
+		pc := r.v[regRA]
+		if pc == 0 {
+			return frames, nil
+		}
+
+		frames = append(frames, Frame{PC: pc, SP: r.v[regRsp]})
+
+		// Look up the caller's frame by the call instruction rather than the
+		// return address, which may belong to the next function.
+		lookup := pc
+		if !signal {
+			lookup--
+		}
+
+		next, isSignal, err := step(mem, ms.lookup(lookup), lookup, r)
+		if err != nil {
+			return frames, err
+		}
+		// A return address outside any executable mapping means the stack
+		// has run out, e.g. where a runtime has switched stacks.
+		if next == nil || ms.lookup(next.v[regRA]) == nil {
+			return frames, nil
+		}
+
+		if next.v[regRsp] <= r.v[regRsp] && !isSignal {
+			return frames, errors.New("stack pointer didn't increase")
+		}
+
+		r, signal = next, isSignal
+	}
+
+	return frames, errors.New("too many frames")
+}
+
+// step returns the caller's registers, or nil at the outermost frame.  It uses
+// CFI when the module has it, and frame pointers otherwise.
+func step(mem io.ReaderAt, m *module, pc uint64, r *regs) (*regs, bool, error) {
+	if m != nil {
+		fdes, _ := m.frames()
+		if f := find(fdes, pc-m.bias); f != nil {
+			next, err := stepCFI(mem, f, pc-m.bias, r)
+			return next, f.cie.signal, err
+		}
+	}
+
+	return stepFramePointer(mem, r)
+}
+
+func stepFramePointer(mem io.ReaderAt, r *regs) (*regs, bool, error) {
+	fp := r.v[regRbp]
+	if !r.valid[regRbp] || fp == 0 {
+		return nil, false, nil
+	}
+
+	next := &regs{}
+
+	savedFP, err := readUint64(mem, fp)
+	if err != nil {
+		return nil, false, err
+	}
+	next.set(regRbp, savedFP)
+
+	ra, err := readUint64(mem, fp+8)
+	if err != nil {
+		return nil, false, err
+	}
+	next.set(regRA, ra)
+	next.set(regRsp, fp+16)
+
+	return next, false, nil
+}
+
+func stepCFI(mem io.ReaderAt, f *fde, pc uint64, r *regs) (*regs, error) {
+	row, err := f.execute(pc)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfa uint64
+	if row.cfaExpr != nil {
+		cfa, err = evaluate(mem, row.cfaExpr, r, nil)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if row.cfaReg >= numRegs || !r.valid[row.cfaReg] {
+			return nil, fmt.Errorf("CFA register %d not available", row.cfaReg)
+		}
+		cfa = r.v[row.cfaReg] + uint64(row.cfaOffset)
+	}
+
+	next := &regs{}
+	for reg, ru := range row.regs {
+		switch ru.kind {
+		case ruleSameValue:
+			if r.valid[reg] {
+				next.set(uint64(reg), r.v[reg])
+			}
+
+		case ruleOffset:
+			v, err := readUint64(mem, cfa+uint64(ru.offset))
+			if err != nil {
+				return nil, err
+			}
+			next.set(uint64(reg), v)
+
+		case ruleValOffset:
+			next.set(uint64(reg), cfa+uint64(ru.offset))
+
+		case ruleRegister:
+			if ru.reg < numRegs && r.valid[ru.reg] {
+				next.set(uint64(reg), r.v[ru.reg])
+			}
+
+		case ruleExpression, ruleValExpression:
+			v, err := evaluate(mem, ru.expr, r, &cfa)
+			if err != nil {
+				return nil, err
+			}
+			if ru.kind == ruleExpression {
+				v, err = readUint64(mem, v)
+				if err != nil {
+					return nil, err
+				}
+			}
+			next.set(uint64(reg), v)
+		}
+	}
+
+	// The return address column is the caller's pc; undefined marks the
+	// outermost frame.
+	ra := f.cie.ra
+	if ra >= numRegs || row.regs[ra].kind == ruleUndefined {
+		return nil, nil
+	}
+	next.v[regRA], next.valid[regRA] = next.v[ra], next.valid[ra]
+
+	next.set(regRsp, cfa)
+
+	return next, nil
+}
+
+// evaluate runs a DWARF expression, supporting the operations found in CFI
+// such as glibc's PLT entries.  If cfa is non-nil, it is pushed first.
+func evaluate(mem io.ReaderAt, expr []byte, r *regs, cfa *uint64) (uint64, error) {
+	var stack []uint64
+	if cfa != nil {
+		stack = append(stack, *cfa)
+	}
+
+	pop := func() uint64 {
+		if len(stack) == 0 {
+			return 0
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+
+	b := &buf{b: expr}
+	for b.off < len(b.b) {
+		op := b.u8()
+
+		switch {
+		case op >= 0x30 && op <= 0x4f: // DW_OP_lit0-31
+			stack = append(stack, uint64(op-0x30))
+			continue
+
+		case op >= 0x70 && op <= 0x8f: // DW_OP_breg0-31
+			reg := uint64(op - 0x70)
+			off := b.sleb()
+			if reg >= numRegs || !r.valid[reg] {
+				return 0, fmt.Errorf("register %d not available", reg)
+			}
+			stack = append(stack, r.v[reg]+uint64(off))
+			continue
+		}
+
+		switch op {
+		case 0x06: // DW_OP_deref
+			v, err := readUint64(mem, pop())
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, v)
+		case 0x08: // DW_OP_const1u
+			stack = append(stack, uint64(b.u8()))
+		case 0x09: // DW_OP_const1s
+			stack = append(stack, uint64(int8(b.u8())))
+		case 0x0a: // DW_OP_const2u
+			stack = append(stack, uint64(b.u16()))
+		case 0x0b: // DW_OP_const2s
+			stack = append(stack, uint64(int16(b.u16())))
+		case 0x0c: // DW_OP_const4u
+			stack = append(stack, uint64(b.u32()))
+		case 0x0d: // DW_OP_const4s
+			stack = append(stack, uint64(int32(b.u32())))
+		case 0x0e, 0x0f: // DW_OP_const8u, DW_OP_const8s
+			stack = append(stack, b.u64())
+		case 0x10: // DW_OP_constu
+			stack = append(stack, b.uleb())
+		case 0x11: // DW_OP_consts
+			stack = append(stack, uint64(b.sleb()))
+		case 0x12: // DW_OP_dup
+			v := pop()
+			stack = append(stack, v, v)
+		case 0x13: // DW_OP_drop
+			pop()
+		case 0x1a: // DW_OP_and
+			y, x := pop(), pop()
+			stack = append(stack, x&y)
+		case 0x1c: // DW_OP_minus
+			y, x := pop(), pop()
+			stack = append(stack, x-y)
+		case 0x21: // DW_OP_or
+			y, x := pop(), pop()
+			stack = append(stack, x|y)
+		case 0x22: // DW_OP_plus
+			y, x := pop(), pop()
+			stack = append(stack, x+y)
+		case 0x23: // DW_OP_plus_uconst
+			stack = append(stack, pop()+b.uleb())
+		case 0x24: // DW_OP_shl
+			y, x := pop(), pop()
+			stack = append(stack, x<<y)
+		case 0x25: // DW_OP_shr
+			y, x := pop(), pop()
+			stack = append(stack, x>>y)
+		case 0x29, 0x2a, 0x2b, 0x2c, 0x2d, 0x2e: // DW_OP_eq, ge, gt, le, lt, ne
+			y, x := int64(pop()), int64(pop())
+			var v bool
+			switch op {
+			case 0x29:
+				v = x == y
+			case 0x2a:
+				v = x >= y
+			case 0x2b:
+				v = x > y
+			case 0x2c:
+				v = x <= y
+			case 0x2d:
+				v = x < y
+			case 0x2e:
+				v = x != y
+			}
+			if v {
+				stack = append(stack, 1)
+			} else {
+				stack = append(stack, 0)
+			}
+		default:
+			return 0, fmt.Errorf("unsupported DWARF expression operation %#x", op)
+		}
+	}
+
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(stack) == 0 {
+		return 0, errors.New("empty DWARF expression")
+	}
+
+	return stack[len(stack)-1], nil
+}
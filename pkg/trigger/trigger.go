@@ -0,0 +1,109 @@
+// Package trigger decides when to dump a process from its resource usage.
+package trigger
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jim-minter/gcore/pkg/proc"
+)
+
+// userHZ is the unit of the CPU times in /proc/<pid>/stat.
+const userHZ = 100
+
+// A Sample is a process's resource usage at a point in time.
+type Sample struct {
+	Time    time.Time
+	CPU     time.Duration // user and system time used so far
+	RSS     uint64
+	Threads int
+}
+
+// Read samples the resource usage of process pid.
+func Read(pid int) (*Sample, error) {
+	now := time.Now()
+
+	stat, err := proc.ReadStat(pid, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	smaps, err := proc.ReadSmaps(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sample{
+		Time:    now,
+		CPU:     time.Duration(stat.Utime+stat.Stime) * time.Second / userHZ,
+		Threads: int(stat.NumThreads),
+	}
+
+	for _, smap := range smaps {
+		s.RSS += smap.Bytes("rss")
+	}
+
+	return s, nil
+}
+
+// Conditions are the thresholds above which a process should be dumped.  Zero
+// values are ignored.
+type Conditions struct {
+	CPU     float64       // percentage of one CPU, so may exceed 100
+	CPUFor  time.Duration // how long CPU must be exceeded for
+	RSS     uint64
+	Threads int
+}
+
+// A Trigger checks successive samples of a process against Conditions.
+type Trigger struct {
+	Conditions
+
+	prev     *Sample
+	cpuSince time.Time
+}
+
+// New returns a Trigger for c.
+func New(c Conditions) *Trigger {
+	return &Trigger{Conditions: c}
+}
+
+// Check records s and returns why the process should be dumped, or "" if no
+// condition holds.  CPU usage is measured since the previous sample, so is
+// never met by the first.
+func (t *Trigger) Check(s *Sample) string {
+	prev := t.prev
+	t.prev = s
+
+	if t.Threads > 0 && s.Threads > t.Threads {
+		return fmt.Sprintf("%d threads exceeds %d", s.Threads, t.Threads)
+	}
+
+	if t.RSS > 0 && s.RSS > t.RSS {
+		return fmt.Sprintf("RSS %d bytes exceeds %d", s.RSS, t.RSS)
+	}
+
+	if t.CPU > 0 && prev != nil && s.Time.After(prev.Time) {
+		cpu := 100 * float64(s.CPU-prev.CPU) / float64(s.Time.Sub(prev.Time))
+		if cpu <= t.CPU {
+			t.cpuSince = time.Time{}
+			return ""
+		}
+
+		if t.cpuSince.IsZero() {
+			t.cpuSince = prev.Time
+		}
+		if d := s.Time.Sub(t.cpuSince); d >= t.CPUFor {
+			return fmt.Sprintf("CPU %.0f%% exceeds %.0f%% for %v", cpu, t.CPU, d.Round(time.Millisecond))
+		}
+	}
+
+	return ""
+}
+
+// Reset forgets the samples seen so far, e.g. after a dump, so that CPU
+// usage has to exceed the threshold for CPUFor again.
+func (t *Trigger) Reset() {
+	t.prev = nil
+	t.cpuSince = time.Time{}
+}
@@ -0,0 +1,96 @@
+package trigger
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	sample := func(secs int, cpu time.Duration, rss uint64, threads int) *Sample {
+		return &Sample{Time: t0.Add(time.Duration(secs) * time.Second), CPU: cpu, RSS: rss, Threads: threads}
+	}
+
+	for _, tt := range []struct {
+		name    string
+		c       Conditions
+		samples []*Sample
+		want    []bool
+	}{
+		{
+			name:    "threads",
+			c:       Conditions{Threads: 4},
+			samples: []*Sample{sample(0, 0, 0, 4), sample(1, 0, 0, 5)},
+			want:    []bool{false, true},
+		},
+		{
+			name:    "rss",
+			c:       Conditions{RSS: 1 << 20},
+			samples: []*Sample{sample(0, 0, 1<<20, 1), sample(1, 0, 1<<20+1, 1)},
+			want:    []bool{false, true},
+		},
+		{
+			name: "cpu",
+			c:    Conditions{CPU: 50},
+			samples: []*Sample{
+				sample(0, 0, 0, 1),
+				sample(1, 400*time.Millisecond, 0, 1),
+				sample(2, 1400*time.Millisecond, 0, 1),
+			},
+			want: []bool{false, false, true},
+		},
+		{
+			name: "cpu across cores",
+			c:    Conditions{CPU: 150},
+			samples: []*Sample{
+				sample(0, 0, 0, 1),
+				sample(1, 2*time.Second, 0, 1),
+			},
+			want: []bool{false, true},
+		},
+		{
+			name: "cpu for",
+			c:    Conditions{CPU: 50, CPUFor: 2 * time.Second},
+			samples: []*Sample{
+				sample(0, 0, 0, 1),
+				sample(1, 1*time.Second, 0, 1),
+				sample(2, 2*time.Second, 0, 1),
+				sample(3, 2*time.Second, 0, 1),
+				sample(4, 3*time.Second, 0, 1),
+				sample(5, 4*time.Second, 0, 1),
+			},
+			want: []bool{false, false, true, false, false, true},
+		},
+		{
+			name:    "nothing",
+			c:       Conditions{},
+			samples: []*Sample{sample(0, 0, 1<<30, 100), sample(1, time.Second, 1<<30, 100)},
+			want:    []bool{false, false},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			trig := New(tt.c)
+			for i, s := range tt.samples {
+				reason := trig.Check(s)
+				if (reason != "") != tt.want[i] {
+					t.Errorf("sample %d: got %q", i, reason)
+				}
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	s, err := Read(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.RSS == 0 {
+		t.Error("got zero RSS")
+	}
+	if s.Threads < 1 {
+		t.Errorf("got %d threads", s.Threads)
+	}
+}